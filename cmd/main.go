@@ -1,12 +1,30 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"larn-line/internal/objectstore"
+	"larn-line/internal/rules"
+	"larn-line/internal/scamcheck"
 	"larn-line/internal/services"
+	"larn-line/internal/speech"
+	"larn-line/internal/store"
+	"larn-line/internal/utils"
 	"log"
+	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 
+	"cloud.google.com/go/firestore"
+	"cloud.google.com/go/storage"
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
+	"google.golang.org/api/option"
 )
 
 func main() {
@@ -20,17 +38,275 @@ func main() {
 		})
 	})
 
-	app, err := services.NewLineService(
-		os.Getenv("LINE_CHANNEL_SECRET"),
-		os.Getenv("LINE_CHANNEL_TOKEN"),
+	// Both backends may use Firestore; share one client between them.
+	firestoreClient := sync.OnceValues(services.NewFirestore)
+
+	storage, err := newStore(os.Getenv("STORE"), firestoreClient)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	idempotency, err := newIdempotencyStore(
+		os.Getenv("IDEMPOTENCY_STORE"),
+		firestoreClient,
+		utils.GetEnvDuration("IDEMPOTENCY_TTL", 24*time.Hour),
 	)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var speechToText speech.SpeechToText
+	if url := os.Getenv("STT_API_URL"); url != "" {
+		speechToText = speech.NewHTTPSpeechToText(speech.HTTPConfig{
+			URL:      url,
+			APIKey:   os.Getenv("STT_API_KEY"),
+			Language: utils.GetEnv("STT_LANGUAGE", "th-TH"),
+			Timeout:  utils.GetEnvDuration("STT_TIMEOUT", 20*time.Second),
+		})
+	}
+
+	var textToSpeech speech.TextToSpeech
+	if url := os.Getenv("TTS_API_URL"); url != "" {
+		textToSpeech = speech.NewHTTPTextToSpeech(speech.HTTPConfig{
+			URL:      url,
+			APIKey:   os.Getenv("TTS_API_KEY"),
+			Language: utils.GetEnv("TTS_LANGUAGE", "th-TH"),
+			Timeout:  utils.GetEnvDuration("TTS_TIMEOUT", 20*time.Second),
+		})
+	}
+
+	objects, err := newObjectStore(os.Getenv("OBJECT_STORE"), r)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	location := thaiTime()
+
+	roster, err := newRoster(os.Getenv("ROSTER_FILE"), services.PickStrategy(utils.GetEnv("ROSTER_STRATEGY", "round_robin")), location)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	scamClassifications := splitList(utils.GetEnv("SCAM_CLASSIFICATIONS", "scam,fake_news"))
+
+	alertRules, err := newRules(os.Getenv("RULES_FILE"), scamClassifications)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	scamChecker, err := scamcheck.NewChecker(splitList(os.Getenv("SCAM_LISTS")))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var suggestions map[string][]string
+	if path := os.Getenv("SUGGESTIONS_FILE"); path != "" {
+		if suggestions, err = services.LoadSuggestions(path); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	app, err := services.NewLineService(services.Config{
+		ChannelSecret: os.Getenv("LINE_CHANNEL_SECRET"),
+		ChannelToken:  os.Getenv("LINE_CHANNEL_TOKEN"),
+
+		Larn: services.NewHTTPLarnClient(services.LarnConfig{
+			BaseURL:      os.Getenv("LARN_API_URL"),
+			Timeout:      utils.GetEnvDuration("LARN_TIMEOUT", 25*time.Second),
+			MaxRetries:   utils.GetEnvInt("LARN_MAX_RETRIES", 1),
+			RetryBackoff: utils.GetEnvDuration("LARN_RETRY_BACKOFF", 500*time.Millisecond),
+
+			StreamTimeout: utils.GetEnvDuration("LARN_STREAM_TIMEOUT", 55*time.Second),
+		}),
+		Store:       storage,
+		Idempotency: idempotency,
+
+		SpeechToText:  speechToText,
+		TextToSpeech:  textToSpeech,
+		Objects:       objects,
+		ReadAloudOnly: os.Getenv("READ_ALOUD_ONLY") == "true",
+
+		FlexReplies: os.Getenv("RENDER_MODE") == "flex",
+		ReadMoreTTL: utils.GetEnvDuration("READ_MORE_TTL", 24*time.Hour),
+
+		HistoryTurns:  utils.GetEnvInt("HISTORY_TURNS", 20),
+		HistoryTokens: utils.GetEnvInt("HISTORY_TOKENS", 3000),
+		AgentSwitch:   services.AgentSwitchPolicy(utils.GetEnv("AGENT_SWITCH", string(services.NewSession))),
+		KeepSessions:  utils.GetEnvInt("KEEP_SESSIONS", 20),
+
+		RecommendTimeout:   utils.GetEnvDuration("RECOMMEND_TIMEOUT", 1500*time.Millisecond),
+		RecommendCacheSize: utils.GetEnvInt("RECOMMEND_CACHE_SIZE", 1000),
+		Suggestions:        suggestions,
+
+		StreamAnswers: os.Getenv("STREAM_ANSWERS") == "true",
+		StreamPushes:  utils.GetEnvInt("STREAM_PUSHES", 2),
+		PushReserve:   int64(utils.GetEnvInt("PUSH_RESERVE", 100)),
+
+		GroupPrefixes: splitList(utils.GetEnv("GROUP_PREFIXES", "หลานเอง")),
+
+		Roster:              roster,
+		ScamClassifications: scamClassifications,
+		Rules:               alertRules,
+		ScamChecker:         scamChecker,
+
+		Workers:   utils.GetEnvInt("WORKERS", 8),
+		QueueSize: utils.GetEnvInt("QUEUE_SIZE", 256),
+	})
 
 	if err != nil {
 		log.Fatal(err)
 	}
 
 	r.POST("/", app.Callback)
+	r.GET("/stats", app.Stats)
+
+	// The operator console is only served when it has a token.
+	if token := os.Getenv("OPERATOR_TOKEN"); token != "" {
+		operator := r.Group("/operator", services.OperatorAuth(token))
+		operator.GET("/conversations", app.OperatorConversations)
+		operator.GET("/conversations/:userId/messages", app.OperatorHistory)
+		operator.POST("/conversations/:userId/messages", app.OperatorReply)
+		operator.POST("/conversations/:userId/close", app.OperatorClose)
+		operator.GET("/events", app.OperatorEvents)
+		operator.GET("/alerts", app.OperatorAlerts)
+		operator.POST("/scam-lists/reload", app.OperatorReloadScamLists)
+	}
+
+	srv := &http.Server{
+		Addr:    ":3000",
+		Handler: r,
+	}
+
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal(err)
+		}
+	}()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go app.RunDigests(ctx, utils.GetEnvInt("DIGEST_HOUR", 19), location)
+	go scamChecker.Watch(ctx, utils.GetEnvDuration("SCAM_LISTS_INTERVAL", time.Minute))
+
+	<-ctx.Done()
+
+	log.Println("Shutting down...")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Print(err)
+	}
+
+	// Events were already acknowledged, so finish them before exiting.
+	app.Close()
+}
+
+// newStore picks the storage backend: "firestore" (default), "sqlite" or
+// "postgres" with DATABASE_URL, or "memory", which needs no credentials.
+func newStore(backend string, firestoreClient func() (*firestore.Client, error)) (store.Store, error) {
+	switch backend {
+	case "", "firestore":
+		client, err := firestoreClient()
+		if err != nil {
+			return nil, err
+		}
+		return store.NewFirestoreStore(client), nil
+	case "sqlite":
+		return store.NewSQLStore(context.Background(), store.SQLite, utils.GetEnv("DATABASE_URL", "larn.db"))
+	case "postgres":
+		return store.NewSQLStore(context.Background(), store.Postgres, os.Getenv("DATABASE_URL"))
+	case "memory":
+		return store.NewMemoryStore(), nil
+	default:
+		return nil, fmt.Errorf("unknown STORE %q", backend)
+	}
+}
+
+func newIdempotencyStore(backend string, firestoreClient func() (*firestore.Client, error), ttl time.Duration) (services.IdempotencyStore, error) {
+	switch backend {
+	case "", "memory":
+		return services.NewMemoryIdempotencyStore(10000, ttl), nil
+	case "firestore":
+		client, err := firestoreClient()
+		if err != nil {
+			return nil, err
+		}
+		return services.NewFirestoreIdempotencyStore(client, ttl), nil
+	default:
+		return nil, fmt.Errorf("unknown IDEMPOTENCY_STORE %q", backend)
+	}
+}
+
+// newObjectStore picks where generated media is kept: "gcs" with
+// GCS_BUCKET, or "local", served by r from MEDIA_DIR under PUBLIC_URL.
+// Without a backend, read aloud is disabled.
+func newObjectStore(backend string, r *gin.Engine) (objectstore.ObjectStore, error) {
+	switch backend {
+	case "":
+		return nil, nil
+	case "gcs":
+		client, err := storage.NewClient(context.Background(), option.WithCredentialsJSON([]byte(os.Getenv("SERVICE_ACCOUNT"))))
+		if err != nil {
+			return nil, err
+		}
+		return objectstore.NewGCSObjectStore(client, os.Getenv("GCS_BUCKET")), nil
+	case "local":
+		dir := utils.GetEnv("MEDIA_DIR", "media")
+		r.Static("/media", dir)
+		return objectstore.NewLocalObjectStore(dir, os.Getenv("PUBLIC_URL")+"/media"), nil
+	default:
+		return nil, fmt.Errorf("unknown OBJECT_STORE %q", backend)
+	}
+}
+
+// thaiTime is the zone working hours and digests are scheduled in. The
+// fixed offset covers hosts without a zone database; Thailand has no DST.
+func thaiTime() *time.Location {
+	location, err := time.LoadLocation("Asia/Bangkok")
+	if err != nil {
+		return time.FixedZone("ICT", 7*60*60)
+	}
+	return location
+}
+
+// newRoster loads volunteers from path. Without a file the bot hands over
+// to its original volunteer account at any hour.
+func newRoster(path string, strategy services.PickStrategy, location *time.Location) (*services.Roster, error) {
+	if path == "" {
+		return services.NewRoster([]services.Volunteer{
+			{Id: "default", Name: "เอง", Url: "https://line.me/ti/p/lP-CvWiMKT"},
+		}, strategy, location)
+	}
+
+	return services.LoadRoster(path, strategy, location)
+}
 
-	r.Run(":3000")
+// newRules loads alert rules from path. Without a file caregivers are
+// alerted about scam questions at most once an hour.
+func newRules(path string, scamClassifications []string) ([]rules.Rule, error) {
+	if path == "" {
+		return []rules.Rule{
+			{
+				Name:            "scam_alert",
+				Classifications: scamClassifications,
+				Action:          services.AlertCaregiversAction,
+				Cooldown:        "1h",
+			},
+		}, nil
+	}
 
+	return rules.LoadRules(path)
+}
+
+// splitList splits a comma separated setting, ignoring empty items.
+func splitList(value string) []string {
+	items := make([]string, 0)
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
 }
@@ -43,4 +43,73 @@ const (
 
 โดยคุณตา/คุณยาย สามารถแคปหน้าจอหรือแชร์ข้อความนั้นมาที่หลานเองเพื่อตรวจสอบความปลอดภัยได้ค่ะ`
 	NEWS_CHECK_MESSAGE = `คุณตา / คุณยายสามารถแชร์ข้อความที่สงสัยมาที่แชทนี้ได้เลยนะคะ`
+	ERROR_MESSAGE      = `ขอโทษนะคะ หลานเองมีปัญหานิดหน่อย 🙏
+รบกวนคุณตา/คุณยายลองส่งข้อความใหม่อีกครั้งนะคะ`
+	UPSTREAM_ERROR_MESSAGE = `ขอโทษนะคะ ตอนนี้หลานเองคิดคำตอบไม่ทัน 🙏
+รบกวนคุณตา/คุณยายรอสักครู่แล้วลองถามใหม่อีกครั้งนะคะ`
+	UNSUPPORTED_MESSAGE = `ขอโทษนะคะ หลานเองยังอ่านข้อความแบบนี้ไม่ได้ 🙏
+รบกวนคุณตา/คุณยายพิมพ์เป็นตัวหนังสือมาแทนนะคะ`
+	AUDIO_HEARD_MESSAGE     = `🎙️ หลานเองได้ยินว่า “%s”`
+	AUDIO_NOT_HEARD_MESSAGE = `ขอโทษนะคะ หลานเองฟังไม่ชัด 🙏
+รบกวนคุณตา/คุณยายลองพูดใหม่อีกครั้ง หรือพิมพ์ข้อความมาก็ได้ค่ะ`
+	READ_ALOUD_ON_MESSAGE = `หลานเองจะอ่านคำตอบให้ฟังด้วยนะคะ 🔊
+ถ้าไม่อยากฟังแล้ว พิมพ์ว่า “ปิดเสียงอ่าน” ได้เลยค่ะ`
+	READ_ALOUD_OFF_MESSAGE = `ปิดเสียงอ่านแล้วค่ะ 🔇
+ถ้าอยากให้หลานเองอ่านให้ฟังอีก พิมพ์ว่า “เปิดเสียงอ่าน” ได้เลยค่ะ`
+	READ_MORE_EMPTY_MESSAGE = `ไม่มีข้อความให้อ่านต่อแล้วค่ะ 🤗`
+	VOLUNTEER_READY_MESSAGE = `พร้อมคุยกับคุณตาคุณยายแล้วค่ะ กดปุ่มข้างล่างได้เลย`
+	NO_VOLUNTEER_MESSAGE    = `ตอนนี้ยังไม่มีหลานว่างคุยเลยค่ะ 🙏 ระหว่างนี้ถามหลานเองได้เลยนะคะ`
+	HUMAN_MODE_MESSAGE      = `รับทราบค่ะ 🙏 เจ้าหน้าที่จะตอบกลับในแชทนี้โดยเร็วที่สุด พิมพ์เล่ารายละเอียดไว้ก่อนได้เลยนะคะ
+ถ้าต้องการกลับไปคุยกับหลานเอง กด "กลับไปคุยกับหลานเอง" ได้ตลอดค่ะ`
+	BOT_MODE_MESSAGE     = `หลานเองกลับมาตอบแล้วค่ะ 😊 มีอะไรให้ช่วยพิมพ์มาได้เลยนะคะ`
+	HUMAN_MODE_IMAGE     = `[รูปภาพ]`
+	HUMAN_MODE_AUDIO     = `[ข้อความเสียง]`
+	LINK_CONSENT_MESSAGE = `เชื่อมต่อกับลูกหลานเพื่อให้ลูกหลานได้รับสรุปว่าคุณตาคุณยายถามหลานเองเรื่องอะไรบ้าง และเรื่องที่อาจเป็นมิจฉาชีพที่ส่งมาตรวจสอบ
+ลูกหลานจะไม่เห็นแชททั้งหมด และยกเลิกได้ตลอดโดยพิมพ์ "ยกเลิกการเชื่อมต่อ"
+ถ้ายินยอม กด "ยินยอม" ได้เลยค่ะ`
+	LINK_CODE_MESSAGE = `ให้ลูกหลานเพิ่มเพื่อนหลานเอง แล้วพิมพ์ในแชทว่า
+รหัส %s
+รหัสนี้ใช้ได้ครั้งเดียวภายใน 10 นาทีค่ะ`
+	LINK_CODE_INVALID_MESSAGE  = `รหัสนี้ใช้ไม่ได้หรือหมดอายุแล้วค่ะ ขอให้คุณตาคุณยายพิมพ์ "เชื่อมต่อลูกหลาน" เพื่อขอรหัสใหม่นะคะ`
+	LINK_CODE_LOCKED_MESSAGE   = `พิมพ์รหัสผิดหลายครั้งแล้วค่ะ รอสักชั่วโมงแล้วลองใหม่นะคะ`
+	LINKED_CAREGIVER_MESSAGE   = `เชื่อมต่อเรียบร้อยแล้วค่ะ 🎉 หลานเองจะส่งสรุปให้ทุกวัน ถ้าอยากได้สัปดาห์ละครั้ง พิมพ์ "สรุปรายสัปดาห์" ได้เลยค่ะ`
+	LINKED_ELDER_MESSAGE       = `ลูกหลานเชื่อมต่อกับคุณตาคุณยายเรียบร้อยแล้วค่ะ 😊`
+	UNLINKED_MESSAGE           = `ยกเลิกการเชื่อมต่อเรียบร้อยแล้วค่ะ`
+	UNLINKED_CAREGIVER_MESSAGE = `คุณตาคุณยายยกเลิกการเชื่อมต่อแล้ว จะไม่ได้รับสรุปอีกค่ะ`
+	DIGEST_DAILY_MESSAGE       = `หลานเองจะส่งสรุปให้ทุกวันค่ะ`
+	DIGEST_WEEKLY_MESSAGE      = `หลานเองจะส่งสรุปให้ทุกวันอาทิตย์ค่ะ`
+
+	DIGEST_HEADER = `📋 สรุปการใช้งานหลานเองของ%s %s
+ถามทั้งหมด %d ครั้ง`
+	DIGEST_SCAM_HEADER   = `⚠️ ส่งมาตรวจสอบมิจฉาชีพ %d ครั้ง`
+	DIGEST_DAILY_PERIOD  = `วันนี้`
+	DIGEST_WEEKLY_PERIOD = `สัปดาห์นี้`
+	DIGEST_DEFAULT_NAME  = `คุณตาคุณยาย`
+	DIGEST_OTHER_TOPIC   = `อื่น ๆ`
+	DIGEST_IMAGE_SCAM    = `รูปภาพ หลานเองตอบว่า "%s"`
+
+	SCAM_ALERT_MESSAGE = `⚠️ %s เพิ่งส่งข้อความที่อาจเป็นมิจฉาชีพมาให้หลานเองตรวจสอบ
+"%s"
+ลองโทรไปพูดคุยและเตือนให้ระวังด้วยนะคะ`
+	SCAM_IMAGE_ALERT_MESSAGE = `⚠️ %s เพิ่งส่งรูปภาพที่อาจเป็นมิจฉาชีพมาให้หลานเองตรวจสอบ
+หลานเองตอบไปว่า "%s"
+ลองโทรไปพูดคุยและเตือนให้ระวังด้วยนะคะ`
+
+	SCAM_DANGEROUS_MESSAGE  = `🚨 อันตราย! หลานเองพบข้อมูลมิจฉาชีพในข้อความนี้ อย่ากดลิงก์ อย่าโทรกลับ และอย่าโอนเงินนะคะ`
+	SCAM_SUSPICIOUS_MESSAGE = `⚠️ ระวังนะคะ ลิงก์นี้อาจไม่ใช่ของจริง หรือถูกย่อไว้จนไม่รู้ว่าจะพาไปที่ไหน อย่ากรอกข้อมูลส่วนตัวหรือรหัสผ่านนะคะ`
+	SCAM_SAFE_MESSAGE       = `✅ หลานเองตรวจแล้ว ลิงก์หรือเบอร์นี้เป็นของจริงค่ะ`
+	SCAM_LOOKALIKE          = `ชื่อคล้าย`
+	SCAM_SHORTENED          = `(ลิงก์ย่อ)`
+
+	NEW_TOPIC_MESSAGE          = `เริ่มเรื่องใหม่แล้วค่ะ 😊 อยากถามอะไรพิมพ์มาได้เลย ถ้าอยากกลับไปคุยเรื่องเดิม กด "เรื่องก่อนหน้า" นะคะ`
+	PREVIOUS_TOPICS_MESSAGE    = `อยากคุยต่อเรื่องไหนคะ เลือกด้านล่างได้เลยค่ะ`
+	NO_PREVIOUS_TOPICS_MESSAGE = `ยังไม่มีเรื่องก่อนหน้าให้คุยต่อค่ะ`
+	RESUMED_TOPIC_MESSAGE      = `กลับมาคุยเรื่อง "%s" ต่อแล้วค่ะ 😊`
+	EARLIER_TOPIC_TITLE        = `เรื่องที่คุยไว้ก่อนหน้านี้`
+
+	GROUP_JOIN_MESSAGE = `สวัสดีค่ะทุกคน 😊 หลานเองมาช่วยตอบคำถามเรื่องโทรศัพท์ ตรวจสอบมิจฉาชีพ และเรื่องทั่วไปในกลุ่มนี้ค่ะ
+อยากถามอะไร แท็ก @หลานเอง หรือพิมพ์ขึ้นต้นว่า "หลานเอง" แล้วตามด้วยคำถามได้เลยนะคะ`
+	GROUP_MEMBER_JOINED_MESSAGE = `ยินดีต้อนรับค่ะ 😊 ถ้ามีอะไรให้หลานเองช่วย แท็ก @หลานเอง หรือพิมพ์ "หลานเอง" แล้วตามด้วยคำถามได้เลยนะคะ`
+	GROUP_HELP_MESSAGE          = `มีอะไรให้หลานเองช่วยคะ พิมพ์คำถามต่อท้ายได้เลย เช่น "หลานเอง วิธีถ่ายภาพหน้าจอ"`
+	PRIVATE_ONLY_MESSAGE        = `เรื่องนี้ต้องคุยกันส่วนตัวค่ะ เพิ่มหลานเองเป็นเพื่อนแล้วพิมพ์ในแชทส่วนตัวได้เลยนะคะ`
 )
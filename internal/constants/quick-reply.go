@@ -4,4 +4,21 @@ const (
 	NEWS_CHECK = "ตรวจสอบข่าวสาร"
 	CALL_LARN  = "โทรหาหลาน"
 	READ_MORE  = "อ่านต่อ"
+
+	ACCEPT_HANDOFF = "คุยกับหลานเลย"
+
+	TALK_TO_OPERATOR = "ขอคุยกับเจ้าหน้าที่"
+	BACK_TO_BOT      = "กลับไปคุยกับหลานเอง"
+
+	LINK_FAMILY   = "เชื่อมต่อลูกหลาน"
+	LINK_CONSENT  = "ยินยอม"
+	UNLINK_FAMILY = "ยกเลิกการเชื่อมต่อ"
+	DIGEST_DAILY  = "สรุปรายวัน"
+	DIGEST_WEEKLY = "สรุปรายสัปดาห์"
+
+	READ_ALOUD_ON  = "เปิดเสียงอ่าน"
+	READ_ALOUD_OFF = "ปิดเสียงอ่าน"
+
+	NEW_TOPIC       = "เริ่มเรื่องใหม่"
+	PREVIOUS_TOPICS = "เรื่องก่อนหน้า"
 )
@@ -0,0 +1,88 @@
+package markup
+
+import "fmt"
+
+// Node is one element of a Larn response.
+type Node interface {
+	node()
+}
+
+// Text is plain text, possibly spanning several lines.
+type Text struct {
+	Value string
+}
+
+// Image is written as [https://...] or [image:https://...].
+type Image struct {
+	URL string
+}
+
+// Link is written as [link:https://...|label]; the label is optional.
+type Link struct {
+	URL   string
+	Label string
+}
+
+// Video is written as [video:https://...|preview]; the preview image is
+// optional.
+type Video struct {
+	URL        string
+	PreviewURL string
+}
+
+// Button is written as [button:label|action], where action is either a URL
+// to open or text to send back; without it the label is sent.
+type Button struct {
+	Label  string
+	Action string
+}
+
+// Separator splits a response into chat bubbles and is written as a run of
+// percent signs, "% % % % %".
+type Separator struct{}
+
+func (Text) node()      {}
+func (Image) node()     {}
+func (Link) node()      {}
+func (Video) node()     {}
+func (Button) node()    {}
+func (Separator) node() {}
+
+// Diagnostic describes markup the parser had to recover from. Pos is a byte
+// offset into the input.
+type Diagnostic struct {
+	Pos     int
+	Message string
+}
+
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%d: %s", d.Pos, d.Message)
+}
+
+type Document struct {
+	Nodes       []Node
+	Diagnostics []Diagnostic
+}
+
+// Chunks splits the document at its separators, dropping empty chunks.
+func (d *Document) Chunks() [][]Node {
+	chunks := make([][]Node, 0)
+	current := make([]Node, 0)
+
+	for _, node := range d.Nodes {
+		if _, ok := node.(Separator); ok {
+			if len(current) > 0 {
+				chunks = append(chunks, current)
+			}
+			current = make([]Node, 0)
+			continue
+		}
+		current = append(current, node)
+	}
+
+	if len(current) > 0 {
+		chunks = append(chunks, current)
+	}
+
+	return chunks
+}
@@ -0,0 +1,76 @@
+package markup
+
+import "regexp"
+
+type TokenKind int
+
+const (
+	TokenText TokenKind = iota
+	TokenSeparator
+	TokenOpen
+	TokenClose
+)
+
+type Token struct {
+	Kind  TokenKind
+	Value string
+	Pos   int
+}
+
+// separator matches three or more percent signs with only spaces or tabs
+// between them. A lone "%" as in "50%" stays text.
+var separator = regexp.MustCompile(`^%(?:[ \t]*%){2,}`)
+
+// Lex splits input into text, separators and brackets.
+func Lex(input string) []Token {
+	tokens := make([]Token, 0)
+	textStart := 0
+
+	flush := func(end int) {
+		if end > textStart {
+			tokens = append(tokens, Token{Kind: TokenText, Value: input[textStart:end], Pos: textStart})
+		}
+	}
+
+	for i := 0; i < len(input); {
+		switch input[i] {
+		case '[', ']':
+			flush(i)
+			kind := TokenOpen
+			if input[i] == ']' {
+				kind = TokenClose
+			}
+			tokens = append(tokens, Token{Kind: kind, Value: input[i : i+1], Pos: i})
+			i++
+			textStart = i
+		case '%':
+			if m := separator.FindString(input[i:]); m != "" {
+				flush(i)
+				tokens = append(tokens, Token{Kind: TokenSeparator, Value: m, Pos: i})
+				i += len(m)
+				textStart = i
+				continue
+			}
+			i++
+		default:
+			i++
+		}
+	}
+
+	flush(len(input))
+
+	return tokens
+}
+
+// Settled returns the length of the part of a response still being written
+// whose chunks can no longer change: everything up to the end of its last
+// separator.
+func Settled(input string) int {
+	settled := 0
+	for _, token := range Lex(input) {
+		if token.Kind == TokenSeparator {
+			settled = token.Pos + len(token.Value)
+		}
+	}
+	return settled
+}
@@ -0,0 +1,96 @@
+package markup
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLex(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []Token
+	}{
+		{
+			name:  "empty",
+			input: "",
+			want:  []Token{},
+		},
+		{
+			name:  "text",
+			input: "สวัสดีค่ะ",
+			want:  []Token{{Kind: TokenText, Value: "สวัสดีค่ะ", Pos: 0}},
+		},
+		{
+			name:  "lone percent",
+			input: "ลด 50% วันนี้",
+			want:  []Token{{Kind: TokenText, Value: "ลด 50% วันนี้", Pos: 0}},
+		},
+		{
+			name:  "two percents",
+			input: "a % % b",
+			want:  []Token{{Kind: TokenText, Value: "a % % b", Pos: 0}},
+		},
+		{
+			name:  "separator",
+			input: "a\n% % %\nb",
+			want: []Token{
+				{Kind: TokenText, Value: "a\n", Pos: 0},
+				{Kind: TokenSeparator, Value: "% % %", Pos: 2},
+				{Kind: TokenText, Value: "\nb", Pos: 7},
+			},
+		},
+		{
+			name:  "separator without spaces",
+			input: "%%%%%",
+			want:  []Token{{Kind: TokenSeparator, Value: "%%%%%", Pos: 0}},
+		},
+		{
+			name:  "brackets",
+			input: "ดู [https://a.th/x.png] นะ",
+			want: []Token{
+				{Kind: TokenText, Value: "ดู ", Pos: 0},
+				{Kind: TokenOpen, Value: "[", Pos: 7},
+				{Kind: TokenText, Value: "https://a.th/x.png", Pos: 8},
+				{Kind: TokenClose, Value: "]", Pos: 26},
+				{Kind: TokenText, Value: " นะ", Pos: 27},
+			},
+		},
+		{
+			name:  "stray close",
+			input: "a]b",
+			want: []Token{
+				{Kind: TokenText, Value: "a", Pos: 0},
+				{Kind: TokenClose, Value: "]", Pos: 1},
+				{Kind: TokenText, Value: "b", Pos: 2},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Lex(tt.input); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Lex(%q) = %+v, want %+v", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSettled(t *testing.T) {
+	tests := []struct {
+		input string
+		want  int
+	}{
+		{"", 0},
+		{"ยังเขียนอยู่", 0},
+		{"a\n% % %\nb", 7},
+		{"a%%%b%%%", 8},
+		{"50% off", 0},
+	}
+
+	for _, tt := range tests {
+		if got := Settled(tt.input); got != tt.want {
+			t.Errorf("Settled(%q) = %d, want %d", tt.input, got, tt.want)
+		}
+	}
+}
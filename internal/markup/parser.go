@@ -0,0 +1,143 @@
+package markup
+
+import (
+	"fmt"
+	"strings"
+)
+
+type parser struct {
+	tokens []Token
+	pos    int
+	doc    *Document
+}
+
+// Parse turns a Larn response into a document. It never fails: markup it
+// cannot make sense of is kept as text and reported in Diagnostics.
+func Parse(input string) *Document {
+	p := &parser{
+		tokens: Lex(input),
+		doc: &Document{
+			Nodes:       make([]Node, 0),
+			Diagnostics: make([]Diagnostic, 0),
+		},
+	}
+
+	for p.pos < len(p.tokens) {
+		token := p.tokens[p.pos]
+		p.pos++
+
+		switch token.Kind {
+		case TokenText, TokenClose:
+			p.text(token.Value)
+		case TokenSeparator:
+			p.doc.Nodes = append(p.doc.Nodes, Separator{})
+		case TokenOpen:
+			p.bracket(token)
+		}
+	}
+
+	return p.doc
+}
+
+// text appends value, merging it into a preceding text node.
+func (p *parser) text(value string) {
+	if n := len(p.doc.Nodes); n > 0 {
+		if last, ok := p.doc.Nodes[n-1].(Text); ok {
+			p.doc.Nodes[n-1] = Text{Value: last.Value + value}
+			return
+		}
+	}
+	p.doc.Nodes = append(p.doc.Nodes, Text{Value: value})
+}
+
+func (p *parser) diagnose(pos int, format string, args ...any) {
+	p.doc.Diagnostics = append(p.doc.Diagnostics, Diagnostic{
+		Pos:     pos,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// bracket parses what follows an opening bracket. When no closing bracket
+// comes before the next bracket or separator, the "[" is kept as text and
+// parsing resumes right after it.
+func (p *parser) bracket(open Token) {
+	var content strings.Builder
+
+	for i := p.pos; i < len(p.tokens); i++ {
+		token := p.tokens[i]
+
+		switch token.Kind {
+		case TokenText:
+			content.WriteString(token.Value)
+			continue
+		case TokenClose:
+			p.pos = i + 1
+			p.directive(open.Pos, content.String())
+			return
+		}
+
+		break
+	}
+
+	p.diagnose(open.Pos, "unclosed '['")
+	p.text(open.Value)
+}
+
+func (p *parser) directive(pos int, content string) {
+	trimmed := strings.TrimSpace(content)
+
+	if isURL(trimmed) {
+		p.doc.Nodes = append(p.doc.Nodes, Image{URL: trimmed})
+		return
+	}
+
+	name, args, ok := strings.Cut(trimmed, ":")
+	if !ok {
+		p.text("[" + content + "]")
+		return
+	}
+
+	first, second, _ := strings.Cut(args, "|")
+	first = strings.TrimSpace(first)
+	second = strings.TrimSpace(second)
+
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "image":
+		if !isURL(first) {
+			p.invalid(pos, content, "image needs a URL")
+			return
+		}
+		p.doc.Nodes = append(p.doc.Nodes, Image{URL: first})
+	case "video":
+		if !isURL(first) || (second != "" && !isURL(second)) {
+			p.invalid(pos, content, "video needs a URL and an optional preview URL")
+			return
+		}
+		p.doc.Nodes = append(p.doc.Nodes, Video{URL: first, PreviewURL: second})
+	case "link":
+		if !isURL(first) {
+			p.invalid(pos, content, "link needs a URL")
+			return
+		}
+		p.doc.Nodes = append(p.doc.Nodes, Link{URL: first, Label: second})
+	case "button":
+		if first == "" {
+			p.invalid(pos, content, "button needs a label")
+			return
+		}
+		p.doc.Nodes = append(p.doc.Nodes, Button{Label: first, Action: second})
+	default:
+		// Ordinary bracketed text such as "[หมายเหตุ: ...]".
+		p.text("[" + content + "]")
+	}
+}
+
+func (p *parser) invalid(pos int, content string, message string) {
+	p.diagnose(pos, "%s: [%s]", message, content)
+	p.text("[" + content + "]")
+}
+
+func isURL(s string) bool {
+	return (strings.HasPrefix(s, "https://") || strings.HasPrefix(s, "http://")) &&
+		!strings.ContainsAny(s, " \t\n")
+}
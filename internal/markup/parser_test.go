@@ -0,0 +1,127 @@
+package markup
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name        string
+		input       string
+		nodes       []Node
+		diagnostics []Diagnostic
+	}{
+		{
+			name:  "empty",
+			input: "",
+			nodes: []Node{},
+		},
+		{
+			name:  "text",
+			input: "สวัสดีค่ะ",
+			nodes: []Node{Text{Value: "สวัสดีค่ะ"}},
+		},
+		{
+			name:  "lone percent",
+			input: "ลด 50%",
+			nodes: []Node{Text{Value: "ลด 50%"}},
+		},
+		{
+			name:  "separator",
+			input: "a\n% % %\nb",
+			nodes: []Node{Text{Value: "a\n"}, Separator{}, Text{Value: "\nb"}},
+		},
+		{
+			name:  "images in one chunk",
+			input: "[https://a.th/1.png][image:https://a.th/2.png]",
+			nodes: []Node{Image{URL: "https://a.th/1.png"}, Image{URL: "https://a.th/2.png"}},
+		},
+		{
+			name:  "link",
+			input: "[link:https://a.th|เว็บไซต์]",
+			nodes: []Node{Link{URL: "https://a.th", Label: "เว็บไซต์"}},
+		},
+		{
+			name:  "video",
+			input: "[video:https://a.th/v.mp4|https://a.th/v.png]",
+			nodes: []Node{Video{URL: "https://a.th/v.mp4", PreviewURL: "https://a.th/v.png"}},
+		},
+		{
+			name:  "button",
+			input: "[button:ถามต่อ]",
+			nodes: []Node{Button{Label: "ถามต่อ"}},
+		},
+		{
+			name:  "bracketed text",
+			input: "[หมายเหตุ: โทรฟรี] และ [ข้อ 1]",
+			nodes: []Node{Text{Value: "[หมายเหตุ: โทรฟรี] และ [ข้อ 1]"}},
+		},
+		{
+			name:  "stray close",
+			input: "a]b",
+			nodes: []Node{Text{Value: "a]b"}},
+		},
+		{
+			name:        "unclosed",
+			input:       "a [b",
+			nodes:       []Node{Text{Value: "a [b"}},
+			diagnostics: []Diagnostic{{Pos: 2, Message: "unclosed '['"}},
+		},
+		{
+			name:        "unclosed before an image",
+			input:       "[a [https://a.th/1.png]",
+			nodes:       []Node{Text{Value: "[a "}, Image{URL: "https://a.th/1.png"}},
+			diagnostics: []Diagnostic{{Pos: 0, Message: "unclosed '['"}},
+		},
+		{
+			name:        "unclosed before a separator",
+			input:       "[a %%% b",
+			nodes:       []Node{Text{Value: "[a "}, Separator{}, Text{Value: " b"}},
+			diagnostics: []Diagnostic{{Pos: 0, Message: "unclosed '['"}},
+		},
+		{
+			name:        "invalid image",
+			input:       "[image:ไม่มีลิงก์]",
+			nodes:       []Node{Text{Value: "[image:ไม่มีลิงก์]"}},
+			diagnostics: []Diagnostic{{Pos: 0, Message: "image needs a URL: [image:ไม่มีลิงก์]"}},
+		},
+		{
+			name:        "invalid button",
+			input:       "ok [button: |x]",
+			nodes:       []Node{Text{Value: "ok [button: |x]"}},
+			diagnostics: []Diagnostic{{Pos: 3, Message: "button needs a label: [button: |x]"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc := Parse(tt.input)
+
+			if !reflect.DeepEqual(doc.Nodes, tt.nodes) {
+				t.Errorf("nodes = %+v, want %+v", doc.Nodes, tt.nodes)
+			}
+
+			diagnostics := tt.diagnostics
+			if diagnostics == nil {
+				diagnostics = []Diagnostic{}
+			}
+			if !reflect.DeepEqual(doc.Diagnostics, diagnostics) {
+				t.Errorf("diagnostics = %+v, want %+v", doc.Diagnostics, diagnostics)
+			}
+		})
+	}
+}
+
+func TestChunks(t *testing.T) {
+	// The chunks before the first separator and after the last are empty.
+	doc := Parse("%%% a %%% [https://a.th/1.png][https://a.th/2.png] %%%")
+
+	want := [][]Node{
+		{Text{Value: " a "}},
+		{Text{Value: " "}, Image{URL: "https://a.th/1.png"}, Image{URL: "https://a.th/2.png"}, Text{Value: " "}},
+	}
+	if got := doc.Chunks(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Chunks() = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+// Activity is a question asked by a user with caregivers, kept for their
+// digest.
+type Activity struct {
+	// Kind is "text" or "image".
+	Kind           string    `json:"kind" firestore:"kind"`
+	Classification string    `json:"classification" firestore:"classification"`
+	Excerpt        string    `json:"excerpt" firestore:"excerpt"`
+	Timestamp      time.Time `json:"timestamp" firestore:"timestamp"`
+}
@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+// Alert is an entry in the audit log of alerts sent by rules.
+type Alert struct {
+	Rule           string    `json:"rule" firestore:"rule"`
+	UserId         string    `json:"userId" firestore:"userId"`
+	Recipients     []string  `json:"recipients" firestore:"recipients"`
+	Classification string    `json:"classification" firestore:"classification"`
+	Excerpt        string    `json:"excerpt" firestore:"excerpt"`
+	Timestamp      time.Time `json:"timestamp" firestore:"timestamp"`
+}
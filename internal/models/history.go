@@ -5,5 +5,17 @@ import "time"
 type History struct {
 	From      string    `json:"from" firestore:"from"`
 	Message   string    `json:"message" firestore:"message"`
-	Timestamp time.Time `json:"timestamp" firestore:"timestamp"`
+	Timestamp time.Time `json:"timestamp" firestore:"timestamp,serverTimestamp"`
+}
+
+// Turn is what answering one question adds to the user's session.
+type Turn struct {
+	Histories []History
+	// Session is set when the turn starts a new session.
+	Session *Session
+	// Legacy is set on a user's first session. The turns saved before
+	// sessions, and their summary, move to it; it is the new session itself
+	// when the question carries on from them. Otherwise it is only saved
+	// when there were any, starting with the first of them.
+	Legacy *Session
 }
@@ -0,0 +1,14 @@
+package models
+
+import "time"
+
+// Session is one topic of conversation. Its turns are sent to Larn together
+// and it can be resumed after the user moved on to another.
+type Session struct {
+	Id    string `json:"id" firestore:"-"`
+	Agent string `json:"agent" firestore:"agent"`
+	// Title is the question that started the session.
+	Title     string     `json:"title" firestore:"title"`
+	StartedAt time.Time  `json:"startedAt" firestore:"startedAt"`
+	EndedAt   *time.Time `json:"endedAt,omitempty" firestore:"endedAt,omitempty"`
+}
@@ -0,0 +1,10 @@
+package models
+
+import "time"
+
+// Summary stands in for the turns that were compacted out of a user's
+// history.
+type Summary struct {
+	Text      string    `json:"text" firestore:"text"`
+	UpdatedAt time.Time `json:"updatedAt" firestore:"updatedAt"`
+}
@@ -1,5 +1,7 @@
 package models
 
+import "time"
+
 type TmpHistory struct {
 	Message interface{}
 }
@@ -24,3 +26,35 @@ func (t *ImageMessage) GetPreview() string {
 func (t *ImageMessage) GetOriginal() string {
 	return t.Original
 }
+
+type AudioMessage struct {
+	Url      string
+	Duration int64
+}
+
+func (t *AudioMessage) GetUrl() string {
+	return t.Url
+}
+
+func (t *AudioMessage) GetDuration() int64 {
+	return t.Duration
+}
+
+// RawMessage is a LINE message kept in its JSON encoding, for message types
+// without a model of their own.
+type RawMessage struct {
+	JSON string
+}
+
+func (t *RawMessage) GetJSON() string {
+	return t.JSON
+}
+
+// PendingAnswer holds the pages of an answer that did not fit in one reply.
+// Pages[0] is page 1, the first page after the reply itself.
+type PendingAnswer struct {
+	AnswerId string
+	Pages    [][]TmpHistory
+	NextPage int
+	ExpireAt time.Time
+}
@@ -0,0 +1,50 @@
+package models
+
+import "time"
+
+type User struct {
+	CurrentAgent string `json:"currentAgent" firestore:"currentAgent"`
+	// SessionId is the conversation new turns belong to. Users from before
+	// sessions have none until their first new one.
+	SessionId string `json:"sessionId,omitempty" firestore:"sessionId,omitempty"`
+	// NewTopic is set when the user asked to start over. Their next
+	// question starts a session.
+	NewTopic bool `json:"newTopic,omitempty" firestore:"newTopic,omitempty"`
+	// ReadAloud sends answers as voice messages as well as text.
+	ReadAloud bool `json:"readAloud" firestore:"readAloud"`
+	// Mode is who answers the user, ModeBot or ModeHuman. Empty means
+	// ModeBot.
+	Mode          string    `json:"mode,omitempty" firestore:"mode"`
+	ModeChangedAt time.Time `json:"modeChangedAt,omitempty" firestore:"modeChangedAt"`
+	// Caregivers are the family accounts linked to this user, and Elders
+	// the users this account looks after. An elder shares activity only
+	// after giving consent at SharingConsentAt.
+	Caregivers       []string   `json:"caregivers,omitempty" firestore:"caregivers,omitempty"`
+	Elders           []string   `json:"elders,omitempty" firestore:"elders,omitempty"`
+	SharingConsentAt *time.Time `json:"sharingConsentAt,omitempty" firestore:"sharingConsentAt,omitempty"`
+	// Digest is how often a caregiver hears about their elders, DigestDaily
+	// or DigestWeekly. Empty means DigestDaily.
+	Digest string `json:"digest,omitempty" firestore:"digest,omitempty"`
+	// LinkFailures are when this account typed a link code that did not
+	// work, kept for an hour to limit guessing.
+	LinkFailures []time.Time `json:"linkFailures,omitempty" firestore:"linkFailures,omitempty"`
+	// Handoff is the last time the user asked to talk to a volunteer.
+	Handoff *Handoff `json:"handoff,omitempty" firestore:"handoff,omitempty"`
+}
+
+type Handoff struct {
+	VolunteerId string    `json:"volunteerId" firestore:"volunteerId"`
+	OfferedAt   time.Time `json:"offeredAt" firestore:"offeredAt"`
+	// AcceptedAt is nil until the user tapped through to the volunteer.
+	AcceptedAt *time.Time `json:"acceptedAt,omitempty" firestore:"acceptedAt,omitempty"`
+}
+
+const (
+	ModeBot   = "bot"
+	ModeHuman = "human"
+)
+
+const (
+	DigestDaily  = "daily"
+	DigestWeekly = "weekly"
+)
@@ -0,0 +1,38 @@
+package objectstore
+
+import (
+	"context"
+	"fmt"
+
+	"cloud.google.com/go/storage"
+)
+
+type gcsObjectStore struct {
+	client *storage.Client
+	bucket string
+}
+
+// NewGCSObjectStore stores objects in a Cloud Storage bucket that must be
+// publicly readable for LINE to fetch them.
+func NewGCSObjectStore(client *storage.Client, bucket string) ObjectStore {
+	return &gcsObjectStore{
+		client: client,
+		bucket: bucket,
+	}
+}
+
+func (s *gcsObjectStore) Put(ctx context.Context, key string, data []byte, contentType string) (string, error) {
+	w := s.client.Bucket(s.bucket).Object(key).NewWriter(ctx)
+	w.ContentType = contentType
+
+	if _, err := w.Write(data); err != nil {
+		w.Close()
+		return "", err
+	}
+
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("https://storage.googleapis.com/%s/%s", s.bucket, key), nil
+}
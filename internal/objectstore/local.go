@@ -0,0 +1,36 @@
+package objectstore
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type localObjectStore struct {
+	dir     string
+	baseURL string
+}
+
+// NewLocalObjectStore writes objects under dir. The caller is responsible
+// for serving dir at baseURL.
+func NewLocalObjectStore(dir string, baseURL string) ObjectStore {
+	return &localObjectStore{
+		dir:     dir,
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+	}
+}
+
+func (s *localObjectStore) Put(ctx context.Context, key string, data []byte, contentType string) (string, error) {
+	path := filepath.Join(s.dir, filepath.FromSlash(key))
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return "", err
+	}
+
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return "", err
+	}
+
+	return s.baseURL + "/" + key, nil
+}
@@ -0,0 +1,10 @@
+package objectstore
+
+import "context"
+
+// ObjectStore holds files the bot generates and has to hand to LINE by URL,
+// such as spoken answers.
+type ObjectStore interface {
+	// Put stores data under key and returns a public HTTPS URL for it.
+	Put(ctx context.Context, key string, data []byte, contentType string) (string, error)
+}
@@ -0,0 +1,182 @@
+package render
+
+import (
+	"encoding/json"
+	"fmt"
+	"larn-line/internal/markup"
+	"regexp"
+	"strings"
+
+	"github.com/line/line-bot-sdk-go/v8/linebot/messaging_api"
+)
+
+const (
+	maxBubbles      = 12
+	maxCarouselSize = 50000
+	stepsAltText    = "วิธีทำทีละขั้นตอน"
+)
+
+// stepStart matches the first line of a step: "1.", "2)" or "ขั้นตอนที่ 3".
+var stepStart = regexp.MustCompile(`^\s*(?:(\d{1,2})[.)]\s|ขั้นตอนที่\s*(\d{1,2}))`)
+
+type step struct {
+	number  string
+	text    []string
+	images  []string
+	actions []messaging_api.ActionInterface
+}
+
+// Flex renders step-by-step answers as a carousel with one bubble per step,
+// preceded by any text before the first step. It returns false when the
+// answer has no steps or the carousel would not fit LINE's Flex limits, in
+// which case the caller should use Messages instead.
+func Flex(doc *markup.Document, quickReply *messaging_api.QuickReply) ([]messaging_api.MessageInterface, bool) {
+	var intro strings.Builder
+	steps := make([]*step, 0)
+
+	write := func(line string) {
+		if len(steps) == 0 {
+			intro.WriteString(line)
+			return
+		}
+		current := steps[len(steps)-1]
+		current.text = append(current.text, line)
+	}
+
+	for _, node := range doc.Nodes {
+		switch n := node.(type) {
+		case markup.Text:
+			for _, line := range strings.SplitAfter(n.Value, "\n") {
+				if m := stepStart.FindStringSubmatch(line); m != nil {
+					steps = append(steps, &step{number: m[1] + m[2]})
+				}
+				write(line)
+			}
+		case markup.Link:
+			write(strings.TrimSpace(n.Label + " " + n.URL))
+		case markup.Image:
+			if len(steps) == 0 || !isHTTPS(n.URL) {
+				return nil, false
+			}
+			current := steps[len(steps)-1]
+			current.images = append(current.images, n.URL)
+		case markup.Video:
+			if len(steps) == 0 {
+				return nil, false
+			}
+			current := steps[len(steps)-1]
+			current.actions = append(current.actions, &messaging_api.UriAction{
+				Label: "ดูวิดีโอ",
+				Uri:   n.URL,
+			})
+		case markup.Button:
+			if len(steps) == 0 {
+				return nil, false
+			}
+			current := steps[len(steps)-1]
+			current.actions = append(current.actions, buttonAction(n))
+		case markup.Separator:
+			write("\n")
+		}
+	}
+
+	if len(steps) < 2 || len(steps) > maxBubbles {
+		return nil, false
+	}
+
+	bubbles := make([]messaging_api.FlexBubble, 0, len(steps))
+	for _, s := range steps {
+		// A bubble has room for one hero image; more would be lost.
+		if len(s.images) > 1 {
+			return nil, false
+		}
+		bubbles = append(bubbles, s.bubble())
+	}
+
+	carousel := &messaging_api.FlexCarousel{
+		Contents: bubbles,
+	}
+
+	encoded, err := json.Marshal(carousel)
+	if err != nil || len(encoded) > maxCarouselSize {
+		return nil, false
+	}
+
+	messages := make([]messaging_api.MessageInterface, 0, 2)
+
+	if text := strings.TrimSpace(intro.String()); text != "" {
+		messages = append(messages, messaging_api.TextMessage{
+			Text:       text,
+			QuickReply: quickReply,
+		})
+	}
+
+	messages = append(messages, messaging_api.FlexMessage{
+		AltText:    truncate(fmt.Sprintf("%s (%d ขั้นตอน)", stepsAltText, len(steps)), maxAltText),
+		Contents:   carousel,
+		QuickReply: quickReply,
+	})
+
+	return messages, true
+}
+
+func (s *step) bubble() messaging_api.FlexBubble {
+	body := &messaging_api.FlexBox{
+		Layout:  messaging_api.FlexBoxLAYOUT_VERTICAL,
+		Spacing: "md",
+		Contents: []messaging_api.FlexComponentInterface{
+			&messaging_api.FlexText{
+				Text:   "ขั้นตอนที่ " + s.number,
+				Size:   "xxl",
+				Weight: messaging_api.FlexTextWEIGHT_BOLD,
+				Color:  "#06C755",
+				Wrap:   true,
+			},
+		},
+	}
+
+	// Flex rejects empty text components.
+	if text := strings.TrimSpace(stepStart.ReplaceAllString(strings.Join(s.text, ""), "")); text != "" {
+		body.Contents = append(body.Contents, &messaging_api.FlexText{
+			Text: text,
+			Size: "xl",
+			Wrap: true,
+		})
+	}
+
+	bubble := messaging_api.FlexBubble{
+		Size: messaging_api.FlexBubbleSIZE_GIGA,
+		Body: body,
+	}
+
+	if len(s.images) == 1 {
+		bubble.Hero = &messaging_api.FlexImage{
+			Url:         s.images[0],
+			Size:        "full",
+			AspectRatio: "3:4",
+			AspectMode:  messaging_api.FlexImageASPECT_MODE_FIT,
+			Action: &messaging_api.UriAction{
+				Uri: s.images[0],
+			},
+		}
+	}
+
+	if len(s.actions) > 0 {
+		buttons := make([]messaging_api.FlexComponentInterface, 0, len(s.actions))
+		for _, action := range s.actions {
+			buttons = append(buttons, &messaging_api.FlexButton{
+				Action: action,
+				Style:  messaging_api.FlexButtonSTYLE_PRIMARY,
+				Height: messaging_api.FlexButtonHEIGHT_MD,
+			})
+		}
+
+		bubble.Footer = &messaging_api.FlexBox{
+			Layout:   messaging_api.FlexBoxLAYOUT_VERTICAL,
+			Spacing:  "sm",
+			Contents: buttons,
+		}
+	}
+
+	return bubble
+}
@@ -0,0 +1,166 @@
+package render
+
+import (
+	"larn-line/internal/markup"
+	"strings"
+
+	"github.com/line/line-bot-sdk-go/v8/linebot/messaging_api"
+)
+
+const (
+	buttonsText    = "เลือกได้เลยค่ะ 👇"
+	maxButtons     = 4
+	maxButtonLabel = 20
+	maxAltText     = 400
+)
+
+// Messages turns a parsed Larn response into LINE messages. Each chunk
+// becomes its text bubbles, with images and videos sent as their own
+// messages and buttons gathered into a buttons template at the end.
+func Messages(doc *markup.Document, quickReply *messaging_api.QuickReply) []messaging_api.MessageInterface {
+	messages := make([]messaging_api.MessageInterface, 0)
+
+	for _, chunk := range doc.Chunks() {
+		var text strings.Builder
+		actions := make([]messaging_api.ActionInterface, 0)
+
+		flush := func() {
+			t := strings.TrimSpace(text.String())
+			if len(t) != 0 {
+				messages = append(messages, messaging_api.TextMessage{
+					Text:       t,
+					QuickReply: quickReply,
+				})
+			}
+			text.Reset()
+		}
+
+		for _, node := range chunk {
+			switch n := node.(type) {
+			case markup.Text:
+				text.WriteString(n.Value)
+			case markup.Link:
+				if n.Label != "" {
+					text.WriteString(n.Label + " ")
+				}
+				text.WriteString(n.URL)
+			case markup.Image:
+				// LINE only accepts images served over HTTPS.
+				if !isHTTPS(n.URL) {
+					continue
+				}
+				flush()
+				messages = append(messages, messaging_api.ImageMessage{
+					OriginalContentUrl: n.URL,
+					PreviewImageUrl:    n.URL,
+					QuickReply:         quickReply,
+				})
+			case markup.Video:
+				if !isHTTPS(n.URL) || !isHTTPS(n.PreviewURL) {
+					text.WriteString(n.URL)
+					continue
+				}
+				flush()
+				messages = append(messages, messaging_api.VideoMessage{
+					OriginalContentUrl: n.URL,
+					PreviewImageUrl:    n.PreviewURL,
+					QuickReply:         quickReply,
+				})
+			case markup.Button:
+				actions = append(actions, buttonAction(n))
+			}
+		}
+
+		flush()
+
+		if len(actions) > 0 {
+			messages = append(messages, buttons(actions, quickReply))
+		}
+	}
+
+	return messages
+}
+
+func buttonAction(button markup.Button) messaging_api.ActionInterface {
+	label := truncate(button.Label, maxButtonLabel)
+
+	if isHTTPS(button.Action) {
+		return &messaging_api.UriAction{
+			Label: label,
+			Uri:   button.Action,
+		}
+	}
+
+	text := button.Action
+	if text == "" {
+		text = button.Label
+	}
+
+	return &messaging_api.MessageAction{
+		Label: label,
+		Text:  text,
+	}
+}
+
+func buttons(actions []messaging_api.ActionInterface, quickReply *messaging_api.QuickReply) messaging_api.TemplateMessage {
+	if len(actions) > maxButtons {
+		actions = actions[:maxButtons]
+	}
+
+	labels := make([]string, 0, len(actions))
+	for _, action := range actions {
+		switch a := action.(type) {
+		case *messaging_api.UriAction:
+			labels = append(labels, a.Label)
+		case *messaging_api.MessageAction:
+			labels = append(labels, a.Label)
+		}
+	}
+
+	return messaging_api.TemplateMessage{
+		AltText: truncate(buttonsText+" "+strings.Join(labels, ", "), maxAltText),
+		Template: &messaging_api.ButtonsTemplate{
+			Text:    buttonsText,
+			Actions: actions,
+		},
+		QuickReply: quickReply,
+	}
+}
+
+// WithQuickReply returns message with its quick reply replaced.
+func WithQuickReply(message messaging_api.MessageInterface, quickReply *messaging_api.QuickReply) messaging_api.MessageInterface {
+	switch m := message.(type) {
+	case messaging_api.TextMessage:
+		m.QuickReply = quickReply
+		return m
+	case messaging_api.ImageMessage:
+		m.QuickReply = quickReply
+		return m
+	case messaging_api.VideoMessage:
+		m.QuickReply = quickReply
+		return m
+	case messaging_api.AudioMessage:
+		m.QuickReply = quickReply
+		return m
+	case messaging_api.TemplateMessage:
+		m.QuickReply = quickReply
+		return m
+	case messaging_api.FlexMessage:
+		m.QuickReply = quickReply
+		return m
+	default:
+		return message
+	}
+}
+
+func isHTTPS(url string) bool {
+	return strings.HasPrefix(url, "https://")
+}
+
+func truncate(s string, max int) string {
+	runes := []rune(s)
+	if len(runes) <= max {
+		return s
+	}
+	return string(runes[:max-1]) + "…"
+}
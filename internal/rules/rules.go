@@ -0,0 +1,228 @@
+// Package rules runs side effects, such as warning caregivers, when an
+// answered message matches a configured rule.
+package rules
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"larn-line/internal/models"
+	"log"
+	"os"
+	"regexp"
+	"slices"
+	"time"
+)
+
+// Event is a message the bot has answered.
+type Event struct {
+	UserId string
+	// Kind is "text" or "image".
+	Kind           string
+	Text           string
+	Classification string
+	Response       string
+}
+
+// Excerpt is what alerts quote from the event. An image has no text of its
+// own, so it is quoted by what Larn found in it.
+func (e Event) Excerpt() string {
+	if e.Kind == "image" {
+		return Excerpt(e.Response)
+	}
+	return Excerpt(e.Text)
+}
+
+// Rule matches events on every condition it sets. A rule without
+// conditions matches nothing, so that a typo cannot alert on every message.
+type Rule struct {
+	Name            string   `json:"name"`
+	Classifications []string `json:"classifications"`
+	Kinds           []string `json:"kinds"`
+	TextPattern     string   `json:"textPattern"`
+	ResponsePattern string   `json:"responsePattern"`
+	// Action names the side effect registered with Engine.Register.
+	Action string `json:"action"`
+	// Cooldown is the least time between two alerts of the rule for the
+	// same user, written like "30m".
+	Cooldown string `json:"cooldown"`
+
+	text     *regexp.Regexp
+	response *regexp.Regexp
+	cooldown time.Duration
+}
+
+func (r *Rule) compile() error {
+	var err error
+
+	if r.Name == "" || r.Action == "" {
+		return fmt.Errorf("rule %q needs a name and an action", r.Name)
+	}
+
+	if r.TextPattern != "" {
+		if r.text, err = regexp.Compile(r.TextPattern); err != nil {
+			return fmt.Errorf("rule %s: %w", r.Name, err)
+		}
+	}
+
+	if r.ResponsePattern != "" {
+		if r.response, err = regexp.Compile(r.ResponsePattern); err != nil {
+			return fmt.Errorf("rule %s: %w", r.Name, err)
+		}
+	}
+
+	if r.Cooldown != "" {
+		if r.cooldown, err = time.ParseDuration(r.Cooldown); err != nil {
+			return fmt.Errorf("rule %s: %w", r.Name, err)
+		}
+	}
+
+	return nil
+}
+
+func (r *Rule) Matches(event Event) bool {
+	if len(r.Classifications) == 0 && len(r.Kinds) == 0 && r.text == nil && r.response == nil {
+		return false
+	}
+
+	if len(r.Classifications) > 0 && !slices.Contains(r.Classifications, event.Classification) {
+		return false
+	}
+	if len(r.Kinds) > 0 && !slices.Contains(r.Kinds, event.Kind) {
+		return false
+	}
+	if r.text != nil && !r.text.MatchString(event.Text) {
+		return false
+	}
+	if r.response != nil && !r.response.MatchString(event.Response) {
+		return false
+	}
+
+	return true
+}
+
+// LoadRules reads a JSON array of rules from path.
+func LoadRules(path string) ([]Rule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var rules []Rule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+
+	return rules, nil
+}
+
+// Action carries out a rule for an event and returns who was alerted. An
+// action that alerted nobody is not logged and starts no cooldown.
+type Action func(ctx context.Context, rule *Rule, event Event) ([]string, error)
+
+// AuditLog keeps the alerts sent. It also keeps cooldowns, so they hold
+// across restarts and replicas.
+type AuditLog interface {
+	AddAlert(ctx context.Context, alert models.Alert) error
+	// ClaimCooldown atomically starts the rule's cooldown for the user and
+	// reports whether it was free to start.
+	ClaimCooldown(ctx context.Context, userId string, rule string, now time.Time, cooldown time.Duration) (bool, error)
+	ReleaseCooldown(ctx context.Context, userId string, rule string, now time.Time) error
+}
+
+type Engine struct {
+	rules   []*Rule
+	actions map[string]Action
+	audit   AuditLog
+}
+
+func NewEngine(rules []Rule, audit AuditLog) (*Engine, error) {
+	compiled := make([]*Rule, 0, len(rules))
+	for i := range rules {
+		rule := rules[i]
+		if err := rule.compile(); err != nil {
+			return nil, err
+		}
+		compiled = append(compiled, &rule)
+	}
+
+	return &Engine{
+		rules:   compiled,
+		actions: make(map[string]Action),
+		audit:   audit,
+	}, nil
+}
+
+// Register names an action rules can refer to. It must be called before
+// events are evaluated.
+func (e *Engine) Register(name string, action Action) {
+	e.actions[name] = action
+}
+
+// Evaluate runs the action of every rule the event matches, unless the rule
+// alerted about the same user within its cooldown. Failures are logged so
+// that one broken rule does not stop the others.
+func (e *Engine) Evaluate(ctx context.Context, event Event) {
+	for _, rule := range e.rules {
+		if !rule.Matches(event) {
+			continue
+		}
+
+		action, ok := e.actions[rule.Action]
+		if !ok {
+			log.Printf("Rule %s has unknown action %q\n", rule.Name, rule.Action)
+			continue
+		}
+
+		now := time.Now()
+
+		// The cooldown is claimed before the action runs, so that events
+		// evaluated at the same time cannot both alert.
+		if rule.cooldown > 0 {
+			claimed, err := e.audit.ClaimCooldown(ctx, event.UserId, rule.Name, now, rule.cooldown)
+			if err != nil {
+				log.Printf("Cannot claim cooldown of rule %s: %+v\n", rule.Name, err)
+				continue
+			}
+			if !claimed {
+				log.Printf("Rule %s is cooling down for %s\n", rule.Name, event.UserId)
+				continue
+			}
+		}
+
+		recipients, err := action(ctx, rule, event)
+		if err != nil {
+			log.Printf("Rule %s failed for %s: %+v\n", rule.Name, event.UserId, err)
+		}
+		if len(recipients) == 0 {
+			if rule.cooldown > 0 {
+				if err := e.audit.ReleaseCooldown(ctx, event.UserId, rule.Name, now); err != nil {
+					log.Printf("Cannot release cooldown of rule %s: %+v\n", rule.Name, err)
+				}
+			}
+			continue
+		}
+
+		if err := e.audit.AddAlert(ctx, models.Alert{
+			Rule:           rule.Name,
+			UserId:         event.UserId,
+			Recipients:     recipients,
+			Classification: event.Classification,
+			Excerpt:        event.Excerpt(),
+			Timestamp:      now,
+		}); err != nil {
+			log.Printf("Cannot log alert of rule %s: %+v\n", rule.Name, err)
+		}
+	}
+}
+
+const excerptLength = 80
+
+// Excerpt shortens text for alerts and digests.
+func Excerpt(text string) string {
+	runes := []rune(text)
+	if len(runes) <= excerptLength {
+		return text
+	}
+	return string(runes[:excerptLength]) + "…"
+}
@@ -0,0 +1,68 @@
+package rules
+
+import (
+	"context"
+	"larn-line/internal/store"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestConcurrentEventsAlertOncePerCooldown(t *testing.T) {
+	audit := store.NewMemoryStore()
+	engine, err := NewEngine([]Rule{{
+		Name:            "scam",
+		Classifications: []string{"scam"},
+		Action:          "alert",
+		Cooldown:        "1h",
+	}}, audit)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var alerts atomic.Int32
+	engine.Register("alert", func(ctx context.Context, rule *Rule, event Event) ([]string, error) {
+		alerts.Add(1)
+		return []string{"Ucaregiver"}, nil
+	})
+
+	var wg sync.WaitGroup
+	for range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			engine.Evaluate(context.Background(), Event{UserId: "U1", Kind: "text", Classification: "scam"})
+		}()
+	}
+	wg.Wait()
+
+	if got := alerts.Load(); got != 1 {
+		t.Errorf("alerted %d times, want once", got)
+	}
+}
+
+func TestAlertingNobodyStartsNoCooldown(t *testing.T) {
+	engine, err := NewEngine([]Rule{{
+		Name:            "scam",
+		Classifications: []string{"scam"},
+		Action:          "alert",
+		Cooldown:        "1h",
+	}}, store.NewMemoryStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var calls int
+	engine.Register("alert", func(ctx context.Context, rule *Rule, event Event) ([]string, error) {
+		calls++
+		return nil, nil
+	})
+
+	event := Event{UserId: "U1", Kind: "text", Classification: "scam"}
+	engine.Evaluate(context.Background(), event)
+	engine.Evaluate(context.Background(), event)
+
+	if calls != 2 {
+		t.Errorf("action ran %d times, want 2", calls)
+	}
+}
@@ -0,0 +1,208 @@
+// Package scamcheck answers what it can about links and phone numbers in a
+// message without asking Larn: block and allow lists, shortened links and
+// domains imitating Thai banks and government agencies.
+package scamcheck
+
+import (
+	"context"
+	"log"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type Level int
+
+const (
+	// Unknown means nothing was found either way.
+	Unknown Level = iota
+	Safe
+	// Suspicious covers links that hide where they go or look like a bank's
+	// or agency's without being listed.
+	Suspicious
+	Dangerous
+)
+
+type Finding struct {
+	// Kind is "url" or "phone".
+	Kind  string
+	Value string
+	Level Level
+	// Reason comes from the list entry that matched.
+	Reason string
+	// Lookalike is the protected domain Value seems to imitate.
+	Lookalike string
+	// Shortened is set when the link goes through a shortener that could
+	// not be resolved.
+	Shortened bool
+}
+
+type Report struct {
+	Findings []Finding
+}
+
+// Level is the worst level found.
+func (r Report) Level() Level {
+	level := Unknown
+	for _, f := range r.Findings {
+		if f.Level > level {
+			level = f.Level
+		}
+	}
+	return level
+}
+
+// Checker checks messages against lists loaded from files, which can be
+// reloaded while it is in use.
+type Checker struct {
+	paths []string
+	lists atomic.Pointer[lists]
+
+	mu      sync.Mutex
+	modTime time.Time
+}
+
+// NewChecker loads the CSV and JSON lists at paths. Without paths only
+// shorteners and lookalikes are checked.
+func NewChecker(paths []string) (*Checker, error) {
+	c := &Checker{paths: paths}
+	if err := c.Reload(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Reload reads the lists again. On error the lists in use are kept.
+func (c *Checker) Reload() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	l, err := loadLists(c.paths)
+	if err != nil {
+		return err
+	}
+
+	c.lists.Store(l)
+	c.modTime = c.latestModTime()
+	return nil
+}
+
+func (c *Checker) latestModTime() time.Time {
+	var latest time.Time
+	for _, path := range c.paths {
+		if info, err := os.Stat(path); err == nil && info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest
+}
+
+// Watch reloads the lists whenever one of the files changes, checking every
+// interval until ctx is done.
+func (c *Checker) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		c.mu.Lock()
+		changed := c.latestModTime().After(c.modTime)
+		c.mu.Unlock()
+
+		if !changed {
+			continue
+		}
+
+		if err := c.Reload(); err != nil {
+			log.Printf("Cannot reload scam lists: %+v\n", err)
+			continue
+		}
+		log.Println("Reloaded scam lists.")
+	}
+}
+
+func (c *Checker) Check(text string) Report {
+	l := c.lists.Load()
+
+	var report Report
+
+	for _, u := range ExtractURLs(text) {
+		report.Findings = append(report.Findings, c.checkURL(l, u))
+	}
+
+	for _, phone := range ExtractPhones(text) {
+		finding := Finding{Kind: "phone", Value: phone}
+		if entry, ok := l.phones[phone]; ok {
+			finding.Level, finding.Reason = entryLevel(entry), entry.Reason
+		}
+		report.Findings = append(report.Findings, finding)
+	}
+
+	return report
+}
+
+func (c *Checker) checkURL(l *lists, u *url.URL) Finding {
+	finding := Finding{Kind: "url", Value: u.String()}
+
+	// Follow wrappers and listed short links to the real destination, a
+	// few hops at most in case they point at each other.
+	for range 5 {
+		if dest := unwrapRedirect(u); dest != nil {
+			u = dest
+			continue
+		}
+		if target, ok := l.short[urlKey(u.String())]; ok {
+			if dest, err := url.Parse(target); err == nil && dest.Hostname() != "" {
+				u = dest
+				continue
+			}
+		}
+		break
+	}
+
+	host := strings.ToLower(strings.TrimPrefix(u.Hostname(), "www."))
+
+	if entry, ok := l.urls[urlKey(u.String())]; ok {
+		finding.Level, finding.Reason = entryLevel(entry), entry.Reason
+		return finding
+	}
+
+	// A listed domain covers its subdomains.
+	for domain := host; domain != ""; {
+		if entry, ok := l.domains[domain]; ok {
+			finding.Level, finding.Reason = entryLevel(entry), entry.Reason
+			return finding
+		}
+		_, parent, found := strings.Cut(domain, ".")
+		if !found {
+			break
+		}
+		domain = parent
+	}
+
+	if protected := lookalike(host); protected != "" {
+		finding.Level, finding.Lookalike = Suspicious, protected
+		return finding
+	}
+
+	if shorteners[host] {
+		finding.Level, finding.Shortened = Suspicious, true
+	}
+
+	return finding
+}
+
+func entryLevel(entry Entry) Level {
+	if entry.Verdict == Block {
+		return Dangerous
+	}
+	return Safe
+}
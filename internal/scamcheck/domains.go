@@ -0,0 +1,183 @@
+package scamcheck
+
+import (
+	"larn-line/internal/utils"
+	"net/url"
+	"slices"
+	"strings"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// shorteners hide where a link goes. Without a list entry for the link the
+// destination cannot be known offline.
+var shorteners = map[string]bool{
+	"bit.ly":      true,
+	"cutt.ly":     true,
+	"goo.gl":      true,
+	"is.gd":       true,
+	"lin.ee":      true,
+	"ow.ly":       true,
+	"rebrand.ly":  true,
+	"s.id":        true,
+	"shorturl.at": true,
+	"t.co":        true,
+	"t.ly":        true,
+	"tiny.cc":     true,
+	"tinyurl.com": true,
+}
+
+// redirectParams are the query parameters wrappers such as Facebook's
+// l.php or Google's /url keep the destination in.
+var redirectParams = map[string]string{
+	"l.facebook.com":  "u",
+	"lm.facebook.com": "u",
+	"www.google.com":  "q",
+	"google.com":      "q",
+	"l.messenger.com": "u",
+	"line.me":         "url",
+}
+
+// unwrapRedirect returns the destination of a redirect wrapper, or nil.
+func unwrapRedirect(u *url.URL) *url.URL {
+	param, ok := redirectParams[strings.ToLower(u.Hostname())]
+	if !ok {
+		return nil
+	}
+
+	target := u.Query().Get(param)
+	if target == "" {
+		return nil
+	}
+
+	dest, err := url.Parse(target)
+	if err != nil || dest.Hostname() == "" {
+		return nil
+	}
+	return dest
+}
+
+// protectedDomains are the banks and agencies scammers most often pose as.
+var protectedDomains = []string{
+	// Banks
+	"kasikornbank.com",
+	"kbank.co.th",
+	"scb.co.th",
+	"bangkokbank.com",
+	"krungthai.com",
+	"krungsri.com",
+	"ttbbank.com",
+	"gsb.or.th",
+	"baac.or.th",
+	"ghbank.co.th",
+	"uob.co.th",
+	"cimbthai.com",
+	"kkpfg.com",
+	"lhbank.co.th",
+	// Government and utilities
+	"go.th",
+	"rd.go.th",
+	"dsi.go.th",
+	"sso.go.th",
+	"dlt.go.th",
+	"royalthaipolice.go.th",
+	"thaiid.go.th",
+	"pea.co.th",
+	"mea.or.th",
+	"thailandpost.co.th",
+}
+
+// subsidiaries are genuine domains of the banks' own companies whose names
+// would otherwise pass for imitations.
+var subsidiaries = map[string]bool{
+	"krungthai-axa.co.th":    true,
+	"krungsriauto.com":       true,
+	"krungsrimarket.com":     true,
+	"krungsriasset.com":      true,
+	"krungsrisecurities.com": true,
+	"krungsrifinnovate.com":  true,
+	"kasikornasset.com":      true,
+	"kasikornresearch.com":   true,
+	"kasikornsecurities.com": true,
+	"kasikornleasing.com":    true,
+}
+
+// registrableDomain is the part of host that was bought, e.g. scb.co.th
+// for www.scb.co.th.
+func registrableDomain(host string) string {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	domain, err := publicsuffix.EffectiveTLDPlusOne(host)
+	if err != nil {
+		return host
+	}
+	return domain
+}
+
+// homoglyphs undoes the substitutions lookalike domains rely on.
+var homoglyphs = strings.NewReplacer("0", "o", "1", "l", "rn", "m", "vv", "w", "-", "")
+
+// lookalike returns the protected domain host seems to imitate, or "" when
+// it is either that domain, a known subsidiary or unrelated to all of them.
+// It is a guess, so callers treat a match as suspicious rather than proof.
+func lookalike(host string) string {
+	domain := registrableDomain(host)
+	host = strings.ToLower(host)
+
+	if subsidiaries[domain] {
+		return ""
+	}
+	for _, protected := range protectedDomains {
+		if domain == protected || strings.HasSuffix(domain, "."+protected) {
+			return ""
+		}
+	}
+
+	label := strings.SplitN(domain, ".", 2)[0]
+	words := strings.Split(label, "-")
+
+	for _, protected := range protectedDomains {
+		if !strings.Contains(protected, ".") || strings.HasPrefix(protected, "go.") {
+			continue
+		}
+
+		// scb.co.th.secure-login.com carries the real name in front.
+		if strings.HasPrefix(host, protected+".") || strings.Contains(host, "."+protected+".") {
+			return protected
+		}
+
+		name := strings.SplitN(protected, ".", 2)[0]
+		if len(name) < 4 {
+			// Short names such as scb are only matched exactly, since
+			// a single edit reaches too many real domains.
+			if homoglyphs.Replace(label) == name && label != name {
+				return protected
+			}
+			continue
+		}
+
+		if label == name {
+			// The real name under another ending, e.g. krungthai.net.
+			return protected
+		}
+
+		if len(words) > 1 && slices.Contains(words, name) {
+			// Extra words hyphenated to it, e.g. kasikornbank-th. Names
+			// run together with a word, such as krungsriauto, are as
+			// likely the bank's own.
+			return protected
+		}
+
+		// One edit on top of the homoglyphs; two would reach ordinary
+		// words, such as krungthep for krungthai.
+		if utils.EditDistance(homoglyphs.Replace(label), homoglyphs.Replace(name)) <= 1 {
+			return protected
+		}
+	}
+
+	// Government sites all end in .go.th, so imitations pretend to.
+	if strings.Contains(host, ".go.th.") || strings.Contains(host, "-go-th") || strings.HasSuffix(label, "goth") {
+		return "go.th"
+	}
+
+	return ""
+}
@@ -0,0 +1,43 @@
+package scamcheck
+
+import "testing"
+
+func TestLookalike(t *testing.T) {
+	tests := []struct {
+		host string
+		want string
+	}{
+		// The real domains and their subdomains.
+		{"kasikornbank.com", ""},
+		{"www.kasikornbank.com", ""},
+		{"online.scb.co.th", ""},
+		{"www.rd.go.th", ""},
+
+		// Subsidiaries and ordinary words.
+		{"krungthai-axa.co.th", ""},
+		{"krungsriauto.com", ""},
+		{"www.krungsrimarket.com", ""},
+		{"krungthep.com", ""},
+		{"google.com", ""},
+		{"shopee.co.th", ""},
+
+		// Imitations.
+		{"kasikornbank-th.com", "kasikornbank.com"},
+		{"th-krungsri.net", "krungsri.com"},
+		{"krungthai.net", "krungthai.com"},
+		{"kasikornbnk.com", "kasikornbank.com"},
+		{"krungtha1.com", "krungthai.com"},
+		{"sc8.co", ""},
+		{"scb.co.th.secure-login.com", "scb.co.th"},
+		{"5cb.com", ""},
+		{"rd.go.th.tax-refund.com", "rd.go.th"},
+		{"refund.go.th.tax.com", "go.th"},
+		{"dsi-go-th.com", "go.th"},
+	}
+
+	for _, tt := range tests {
+		if got := lookalike(tt.host); got != tt.want {
+			t.Errorf("lookalike(%q) = %q, want %q", tt.host, got, tt.want)
+		}
+	}
+}
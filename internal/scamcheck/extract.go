@@ -0,0 +1,79 @@
+package scamcheck
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// urlPattern finds links with a scheme, starting with www., or bare domains
+// under the endings scammers use most in Thai SMS and LINE messages.
+var urlPattern = regexp.MustCompile(`(?i)\b(?:https?://[^\s<>"']+|www\.[^\s<>"']+|[a-z0-9][a-z0-9-]*(?:\.[a-z0-9-]+)*\.(?:com|net|org|info|xyz|top|shop|online|site|club|vip|cc|me|ly|gl|gd|at|id|co|in\.th|co\.th|or\.th|go\.th|ac\.th|th)(?:/[^\s<>"']*)?)`)
+
+// phonePattern finds Thai numbers written with spaces or dashes, in local
+// form (08x, 02) or international form (+66 8x).
+var phonePattern = regexp.MustCompile(`(?:\+66|0066|\b0)[\s-]?\d(?:[\s-]?\d){7,8}\b`)
+
+// ExtractURLs returns the links in text, with a scheme added where it was
+// left out.
+func ExtractURLs(text string) []*url.URL {
+	urls := make([]*url.URL, 0)
+	seen := make(map[string]bool)
+
+	for _, match := range urlPattern.FindAllString(text, -1) {
+		match = strings.TrimRight(match, ".,;:!?)]}'\"")
+		if !strings.Contains(strings.ToLower(match), "://") {
+			match = "http://" + match
+		}
+
+		u, err := url.Parse(match)
+		if err != nil || u.Hostname() == "" {
+			continue
+		}
+
+		if !seen[u.String()] {
+			seen[u.String()] = true
+			urls = append(urls, u)
+		}
+	}
+
+	return urls
+}
+
+// ExtractPhones returns the Thai phone numbers in text, normalized to local
+// form without separators, such as "0812345678".
+func ExtractPhones(text string) []string {
+	phones := make([]string, 0)
+	seen := make(map[string]bool)
+
+	for _, match := range phonePattern.FindAllString(text, -1) {
+		phone := NormalizePhone(match)
+		if len(phone) < 9 || len(phone) > 10 || seen[phone] {
+			continue
+		}
+
+		seen[phone] = true
+		phones = append(phones, phone)
+	}
+
+	return phones
+}
+
+// NormalizePhone drops separators and turns +66 into a leading 0.
+func NormalizePhone(phone string) string {
+	digits := strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, phone)
+
+	switch {
+	case strings.HasPrefix(phone, "+66"):
+		return "0" + strings.TrimPrefix(digits, "66")
+	case strings.HasPrefix(digits, "0066"):
+		return "0" + strings.TrimPrefix(digits, "0066")
+	default:
+		return digits
+	}
+}
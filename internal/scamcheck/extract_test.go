@@ -0,0 +1,55 @@
+package scamcheck
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExtractURLs(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"", []string{}},
+		{"ไม่มีลิงก์ค่ะ", []string{}},
+		{"กดที่ https://bit.ly/3abc นะคะ", []string{"https://bit.ly/3abc"}},
+		{"เข้า www.kasikornbank.com/th ได้เลย", []string{"http://www.kasikornbank.com/th"}},
+		{"ลงทะเบียนที่ rd-go-th.xyz/refund.", []string{"http://rd-go-th.xyz/refund"}},
+		{"(ดู scb.co.th)", []string{"http://scb.co.th"}},
+		{"a.com และ a.com อีกที", []string{"http://a.com"}},
+		{"HTTPS://Example.COM/x และ t.ly/abc", []string{"https://Example.COM/x", "http://t.ly/abc"}},
+		{"ราคา 1.5 บาท ลด 50%", []string{}},
+	}
+
+	for _, tt := range tests {
+		got := make([]string, 0)
+		for _, u := range ExtractURLs(tt.text) {
+			got = append(got, u.String())
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ExtractURLs(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestExtractPhones(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"", []string{}},
+		{"โทร 0812345678 ด่วน", []string{"0812345678"}},
+		{"โทร 081-234-5678", []string{"0812345678"}},
+		{"โทร 081 234 5678 หรือ 02-123-4567", []string{"0812345678", "021234567"}},
+		{"+66 81 234 5678", []string{"0812345678"}},
+		{"0066812345678", []string{"0812345678"}},
+		{"0812345678 และ +66812345678", []string{"0812345678"}},
+		{"รหัส 123456 และเลขบัญชี 1234567890", []string{}},
+	}
+
+	for _, tt := range tests {
+		if got := ExtractPhones(tt.text); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ExtractPhones(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
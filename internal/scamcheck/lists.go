@@ -0,0 +1,187 @@
+package scamcheck
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type EntryType string
+
+const (
+	EntryDomain EntryType = "domain"
+	EntryURL    EntryType = "url"
+	EntryPhone  EntryType = "phone"
+	// EntryShortLink maps a short link to where it leads, for shorteners
+	// that cannot be resolved offline otherwise.
+	EntryShortLink EntryType = "short"
+)
+
+type ListVerdict string
+
+const (
+	Block ListVerdict = "block"
+	Allow ListVerdict = "allow"
+)
+
+// Entry is one line of a block or allow list. In CSV the columns are
+// type,value,verdict,reason with an optional header, except that short
+// links give their destination in place of the verdict. JSON files hold an
+// array of entries.
+type Entry struct {
+	Type    EntryType   `json:"type"`
+	Value   string      `json:"value"`
+	Verdict ListVerdict `json:"verdict"`
+	Reason  string      `json:"reason"`
+	Target  string      `json:"target"`
+}
+
+// lists is an immutable index of the entries of every file.
+type lists struct {
+	domains map[string]Entry
+	urls    map[string]Entry
+	phones  map[string]Entry
+	short   map[string]string
+}
+
+func newLists() *lists {
+	return &lists{
+		domains: make(map[string]Entry),
+		urls:    make(map[string]Entry),
+		phones:  make(map[string]Entry),
+		short:   make(map[string]string),
+	}
+}
+
+func (l *lists) add(entry Entry) error {
+	value := strings.TrimSpace(entry.Value)
+	if value == "" {
+		return errors.New("empty value")
+	}
+
+	switch entry.Type {
+	case EntryShortLink:
+		if entry.Target == "" {
+			return fmt.Errorf("short link %s has no target", value)
+		}
+		l.short[urlKey(value)] = entry.Target
+		return nil
+	}
+
+	switch entry.Verdict {
+	case Block, Allow:
+	default:
+		return fmt.Errorf("unknown verdict %q for %s", entry.Verdict, value)
+	}
+
+	switch entry.Type {
+	case EntryDomain:
+		l.domains[strings.ToLower(strings.TrimPrefix(value, "www."))] = entry
+	case EntryURL:
+		l.urls[urlKey(value)] = entry
+	case EntryPhone:
+		l.phones[NormalizePhone(value)] = entry
+	default:
+		return fmt.Errorf("unknown type %q for %s", entry.Type, value)
+	}
+
+	return nil
+}
+
+// urlKey compares links without their scheme, a leading www. or a
+// trailing slash.
+func urlKey(link string) string {
+	link = strings.ToLower(strings.TrimSpace(link))
+	if i := strings.Index(link, "://"); i >= 0 {
+		link = link[i+3:]
+	}
+	link = strings.TrimPrefix(link, "www.")
+	return strings.TrimRight(link, "/")
+}
+
+func loadLists(paths []string) (*lists, error) {
+	l := newLists()
+
+	for _, path := range paths {
+		entries, err := readEntries(path)
+		if err != nil {
+			return nil, fmt.Errorf("read %s: %w", path, err)
+		}
+
+		for i, entry := range entries {
+			if err := l.add(entry); err != nil {
+				return nil, fmt.Errorf("%s entry %d: %w", path, i+1, err)
+			}
+		}
+	}
+
+	return l, nil
+}
+
+func readEntries(path string) ([]Entry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		var entries []Entry
+		if err := json.NewDecoder(f).Decode(&entries); err != nil {
+			return nil, err
+		}
+		return entries, nil
+	case ".csv":
+		return readCSV(f)
+	default:
+		return nil, errors.New("lists must be .csv or .json")
+	}
+}
+
+func readCSV(r io.Reader) ([]Entry, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.Comment = '#'
+	reader.TrimLeadingSpace = true
+
+	entries := make([]Entry, 0)
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if len(record) < 3 {
+			return nil, fmt.Errorf("line needs type,value,verdict: %v", record)
+		}
+		if strings.EqualFold(record[0], "type") {
+			continue
+		}
+
+		entry := Entry{
+			Type:    EntryType(strings.ToLower(record[0])),
+			Value:   record[1],
+			Verdict: ListVerdict(strings.ToLower(record[2])),
+		}
+		if len(record) > 3 {
+			entry.Reason = record[3]
+		}
+		if entry.Type == EntryShortLink {
+			// Short links have no verdict; the third column is the target.
+			entry.Target, entry.Verdict = record[2], ""
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,69 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"larn-line/internal/constants"
+	"larn-line/internal/rules"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AlertCaregiversAction pushes a warning with the suspicious message to the
+// caregivers of an elder who agreed to share.
+const AlertCaregiversAction = "alert_caregivers"
+
+func (app *LineService) alertCaregivers(ctx context.Context, rule *rules.Rule, event rules.Event) ([]string, error) {
+	user, err := app.store.GetUser(ctx, event.UserId)
+	if err != nil {
+		return nil, err
+	}
+
+	if user.SharingConsentAt == nil || len(user.Caregivers) == 0 {
+		return nil, nil
+	}
+
+	name := constants.DIGEST_DEFAULT_NAME
+	if profile, err := app.bot.GetProfile(event.UserId); err == nil && profile.DisplayName != "" {
+		name = profile.DisplayName
+	}
+
+	format := constants.SCAM_ALERT_MESSAGE
+	if event.Kind == "image" {
+		format = constants.SCAM_IMAGE_ALERT_MESSAGE
+	}
+	text := fmt.Sprintf(format, name, event.Excerpt())
+
+	alerted := make([]string, 0, len(user.Caregivers))
+	for _, caregiverId := range user.Caregivers {
+		if err := app.pushText(caregiverId, text, nil); err != nil {
+			log.Printf("Cannot alert %s: %+v\n", caregiverId, err)
+			continue
+		}
+		alerted = append(alerted, caregiverId)
+	}
+
+	return alerted, nil
+}
+
+// OperatorAlerts lists the alerts sent within the "since" duration, a day
+// by default.
+func (app *LineService) OperatorAlerts(c *gin.Context) {
+	since, err := time.ParseDuration(c.DefaultQuery("since", "24h"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	alerts, err := app.store.ListAlerts(c.Request.Context(), time.Now().Add(-since))
+	if err != nil {
+		log.Printf("Cannot list alerts: %+v\n", err)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, alerts)
+}
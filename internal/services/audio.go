@@ -0,0 +1,63 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"larn-line/internal/constants"
+	"log"
+	"strings"
+
+	"github.com/line/line-bot-sdk-go/v8/linebot/messaging_api"
+	"github.com/line/line-bot-sdk-go/v8/linebot/webhook"
+)
+
+// handleAudioMessage transcribes a voice message and answers it as if the
+// user had typed it, echoing the transcript so they can tell whether they
+// were heard correctly. Without speech to text the user is asked to type.
+func (app *LineService) handleAudioMessage(userId string, message webhook.AudioMessageContent, replyToken string) error {
+	if app.speechToText == nil {
+		app.replyText(replyToken, constants.UNSUPPORTED_MESSAGE)
+		return nil
+	}
+
+	ctx := context.Background()
+
+	audio, contentType, err := app.getMessageContent(ctx, message.Id, message.ContentProvider)
+	if err != nil {
+		return err
+	}
+
+	if contentType == "" {
+		contentType = "audio/m4a"
+	}
+
+	text, err := app.speechToText.Transcribe(ctx, audio, contentType)
+	if err != nil {
+		return upstreamError("transcribe audio", err)
+	}
+
+	text = strings.TrimSpace(text)
+
+	if text == "" {
+		if _, err := app.bot.ReplyMessage(
+			&messaging_api.ReplyMessageRequest{
+				ReplyToken: replyToken,
+				Messages: []messaging_api.MessageInterface{
+					&messaging_api.TextMessage{
+						Text:       constants.AUDIO_NOT_HEARD_MESSAGE,
+						QuickReply: app.quickReplies,
+					},
+				},
+			},
+		); err != nil {
+			log.Print(err)
+		}
+		return nil
+	}
+
+	return app.handleLarnMessage(userId, text, replyToken,
+		messaging_api.TextMessage{
+			Text: fmt.Sprintf(constants.AUDIO_HEARD_MESSAGE, text),
+		},
+	)
+}
@@ -0,0 +1,381 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"larn-line/internal/constants"
+	"larn-line/internal/models"
+	"larn-line/internal/rules"
+	"larn-line/internal/store"
+	"log"
+	"math/big"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/line/line-bot-sdk-go/v8/linebot/messaging_api"
+)
+
+const (
+	consentLinkAction = "consent_link"
+
+	linkCodeTTL = 10 * time.Minute
+	// Codes are six digits, so an account gets maxLinkFailures wrong ones
+	// per linkFailureWindow.
+	maxLinkFailures   = 5
+	linkFailureWindow = time.Hour
+	// activityRetention is kept a little longer than the weekly digest
+	// looks back.
+	activityRetention = 8 * 24 * time.Hour
+)
+
+// linkCodePattern is what a caregiver types to link, e.g. "รหัส 123456".
+var linkCodePattern = regexp.MustCompile(`^\s*รหัส\s*(\d{6})\s*$`)
+
+// askLinkConsent explains what caregivers will see before the elder agrees
+// to share it.
+func (app *LineService) askLinkConsent(userId string, replyToken string) error {
+	if _, err := app.bot.ReplyMessage(
+		&messaging_api.ReplyMessageRequest{
+			ReplyToken: replyToken,
+			Messages: []messaging_api.MessageInterface{
+				&messaging_api.TextMessage{
+					Text: constants.LINK_CONSENT_MESSAGE,
+					QuickReply: &messaging_api.QuickReply{
+						Items: []messaging_api.QuickReplyItem{
+							{
+								Action: PostbackAction(constants.LINK_CONSENT, constants.LINK_CONSENT, consentLinkAction, nil),
+							},
+						},
+					},
+				},
+			},
+		},
+	); err != nil {
+		log.Print(err)
+	}
+
+	return nil
+}
+
+func newLinkCode() (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%06d", n.Int64()), nil
+}
+
+// consentLink records the elder's consent and gives them a one-time code
+// for their caregiver to type.
+func (app *LineService) consentLink(userId string, replyToken string) error {
+	ctx := context.Background()
+	now := time.Now()
+
+	if err := app.store.UpdateUser(ctx, userId, func(user *models.User) error {
+		user.SharingConsentAt = &now
+		return nil
+	}); err != nil {
+		return storageError("save consent", err)
+	}
+
+	code, err := newLinkCode()
+	if err != nil {
+		return err
+	}
+
+	if err := app.store.SaveLinkCode(ctx, code, userId, now.Add(linkCodeTTL)); err != nil {
+		return storageError("save link code", err)
+	}
+
+	app.replyText(replyToken, fmt.Sprintf(constants.LINK_CODE_MESSAGE, code))
+	return nil
+}
+
+// linkCaregiver links the user typing a code to the elder who made it.
+func (app *LineService) linkCaregiver(caregiverId string, code string, replyToken string) error {
+	ctx := context.Background()
+	now := time.Now()
+
+	locked := false
+	if err := app.store.UpdateUser(ctx, caregiverId, func(user *models.User) error {
+		user.LinkFailures = slices.DeleteFunc(user.LinkFailures, func(t time.Time) bool {
+			return now.Sub(t) > linkFailureWindow
+		})
+		locked = len(user.LinkFailures) >= maxLinkFailures
+		return nil
+	}); err != nil {
+		return storageError("check link failures", err)
+	}
+	if locked {
+		app.replyText(replyToken, constants.LINK_CODE_LOCKED_MESSAGE)
+		return nil
+	}
+
+	elderId, err := app.store.TakeLinkCode(ctx, code)
+	if errors.Is(err, store.ErrNotFound) || elderId == caregiverId {
+		if err := app.store.UpdateUser(ctx, caregiverId, func(user *models.User) error {
+			user.LinkFailures = append(user.LinkFailures, now)
+			return nil
+		}); err != nil {
+			log.Printf("Cannot record link failure of %s: %+v\n", caregiverId, err)
+		}
+
+		app.replyText(replyToken, constants.LINK_CODE_INVALID_MESSAGE)
+		return nil
+	}
+	if err != nil {
+		return storageError("take link code", err)
+	}
+
+	if err := app.store.UpdateUser(ctx, elderId, func(user *models.User) error {
+		if !slices.Contains(user.Caregivers, caregiverId) {
+			user.Caregivers = append(user.Caregivers, caregiverId)
+		}
+		return nil
+	}); err != nil {
+		return storageError("link caregiver", err)
+	}
+
+	if err := app.store.UpdateUser(ctx, caregiverId, func(user *models.User) error {
+		if !slices.Contains(user.Elders, elderId) {
+			user.Elders = append(user.Elders, elderId)
+		}
+		return nil
+	}); err != nil {
+		return storageError("link elder", err)
+	}
+
+	app.replyText(replyToken, constants.LINKED_CAREGIVER_MESSAGE)
+
+	if err := app.pushText(elderId, constants.LINKED_ELDER_MESSAGE, app.quickReplies); err != nil {
+		log.Printf("Cannot tell %s about the link: %+v\n", elderId, err)
+	}
+
+	return nil
+}
+
+// unlink removes every link the user has, as an elder or as a caregiver.
+// An elder unlinking also withdraws consent and has their activity deleted.
+func (app *LineService) unlink(userId string, replyToken string) error {
+	if err := app.removeLinks(context.Background(), userId); err != nil {
+		return err
+	}
+
+	app.replyText(replyToken, constants.UNLINKED_MESSAGE)
+	return nil
+}
+
+// removeLinks takes the user out of the lists of everyone linked to them,
+// and empties their own, telling their caregivers. It is also how a user
+// who blocked the bot leaves before they are deleted.
+func (app *LineService) removeLinks(ctx context.Context, userId string) error {
+	var caregivers, elders []string
+	if err := app.store.UpdateUser(ctx, userId, func(user *models.User) error {
+		caregivers, elders = user.Caregivers, user.Elders
+		user.Caregivers = nil
+		user.Elders = nil
+		user.SharingConsentAt = nil
+		return nil
+	}); err != nil {
+		return storageError("unlink", err)
+	}
+
+	for _, caregiverId := range caregivers {
+		if err := app.store.UpdateUser(ctx, caregiverId, func(user *models.User) error {
+			user.Elders = slices.DeleteFunc(user.Elders, func(id string) bool { return id == userId })
+			return nil
+		}); err != nil {
+			log.Printf("Cannot unlink %s from %s: %+v\n", caregiverId, userId, err)
+		}
+
+		if err := app.pushText(caregiverId, constants.UNLINKED_CAREGIVER_MESSAGE, nil); err != nil {
+			log.Printf("Cannot tell %s about the unlink: %+v\n", caregiverId, err)
+		}
+	}
+
+	for _, elderId := range elders {
+		if err := app.store.UpdateUser(ctx, elderId, func(user *models.User) error {
+			user.Caregivers = slices.DeleteFunc(user.Caregivers, func(id string) bool { return id == userId })
+			return nil
+		}); err != nil {
+			log.Printf("Cannot unlink %s from %s: %+v\n", userId, elderId, err)
+		}
+	}
+
+	if err := app.store.DeleteActivities(ctx, userId, time.Now()); err != nil {
+		log.Printf("Cannot delete activities of %s: %+v\n", userId, err)
+	}
+
+	return nil
+}
+
+func (app *LineService) setDigest(userId string, digest string, replyToken string) error {
+	if err := app.store.UpdateUser(context.Background(), userId, func(user *models.User) error {
+		user.Digest = digest
+		return nil
+	}); err != nil {
+		return storageError("update digest", err)
+	}
+
+	text := constants.DIGEST_DAILY_MESSAGE
+	if digest == models.DigestWeekly {
+		text = constants.DIGEST_WEEKLY_MESSAGE
+	}
+
+	app.replyText(replyToken, text)
+	return nil
+}
+
+// recordActivity keeps what a user with caregivers asked about, quoted as
+// alerts quote it.
+func (app *LineService) recordActivity(ctx context.Context, event rules.Event) {
+	if err := app.store.AddActivity(ctx, event.UserId, models.Activity{
+		Kind:           event.Kind,
+		Classification: event.Classification,
+		Excerpt:        event.Excerpt(),
+		Timestamp:      time.Now(),
+	}); err != nil {
+		log.Printf("Cannot record activity of %s: %+v\n", event.UserId, err)
+	}
+}
+
+func (app *LineService) isScamCheck(activity models.Activity) bool {
+	return activity.Kind == "image" || slices.Contains(app.scamClassifications, activity.Classification)
+}
+
+// RunDigests pushes caregivers their digest every day at hour, weekly ones
+// on Sundays, until ctx is done.
+func (app *LineService) RunDigests(ctx context.Context, hour int, location *time.Location) {
+	for {
+		now := time.Now().In(location)
+		next := time.Date(now.Year(), now.Month(), now.Day(), hour, 0, 0, 0, location)
+		if !next.After(now) {
+			next = next.AddDate(0, 0, 1)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Until(next)):
+		}
+
+		app.sendDigests(ctx, next)
+	}
+}
+
+func (app *LineService) sendDigests(ctx context.Context, now time.Time) {
+	elderIds, err := app.store.ListUsersWithCaregivers(ctx)
+	if err != nil {
+		log.Printf("Cannot list users for digests: %+v\n", err)
+		return
+	}
+
+	for _, elderId := range elderIds {
+		elder, err := app.store.GetUser(ctx, elderId)
+		if err != nil {
+			log.Printf("Cannot get user %s: %+v\n", elderId, err)
+			continue
+		}
+
+		var daily, weekly []string
+		for _, caregiverId := range elder.Caregivers {
+			caregiver, err := app.store.GetUser(ctx, caregiverId)
+			if err != nil {
+				log.Printf("Cannot get user %s: %+v\n", caregiverId, err)
+				continue
+			}
+
+			if caregiver.Digest == models.DigestWeekly {
+				weekly = append(weekly, caregiverId)
+			} else {
+				daily = append(daily, caregiverId)
+			}
+		}
+
+		app.sendDigest(ctx, elderId, daily, now.AddDate(0, 0, -1), constants.DIGEST_DAILY_PERIOD)
+		if now.Weekday() == time.Sunday {
+			app.sendDigest(ctx, elderId, weekly, now.AddDate(0, 0, -7), constants.DIGEST_WEEKLY_PERIOD)
+		}
+
+		if err := app.store.DeleteActivities(ctx, elderId, now.Add(-activityRetention)); err != nil {
+			log.Printf("Cannot delete activities of %s: %+v\n", elderId, err)
+		}
+	}
+}
+
+// sendDigest pushes a summary of the elder's activity since the given time.
+// Caregivers hear nothing when there is nothing to tell.
+func (app *LineService) sendDigest(ctx context.Context, elderId string, caregivers []string, since time.Time, period string) {
+	if len(caregivers) == 0 {
+		return
+	}
+
+	activities, err := app.store.ListActivities(ctx, elderId, since)
+	if err != nil {
+		log.Printf("Cannot list activities of %s: %+v\n", elderId, err)
+		return
+	}
+	if len(activities) == 0 {
+		return
+	}
+
+	text := app.digestText(ctx, elderId, activities, period)
+	for _, caregiverId := range caregivers {
+		if err := app.pushText(caregiverId, text, nil); err != nil {
+			log.Printf("Cannot push digest to %s: %+v\n", caregiverId, err)
+		}
+	}
+}
+
+func (app *LineService) digestText(ctx context.Context, elderId string, activities []models.Activity, period string) string {
+	name := constants.DIGEST_DEFAULT_NAME
+	if profile, err := app.bot.GetProfile(elderId); err == nil && profile.DisplayName != "" {
+		name = profile.DisplayName
+	}
+
+	topics := make(map[string]int)
+	scams := make([]models.Activity, 0)
+	for _, activity := range activities {
+		topic := activity.Classification
+		if topic == "" {
+			topic = constants.DIGEST_OTHER_TOPIC
+		}
+		topics[topic]++
+
+		if app.isScamCheck(activity) {
+			scams = append(scams, activity)
+		}
+	}
+
+	names := make([]string, 0, len(topics))
+	for topic := range topics {
+		names = append(names, topic)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return topics[names[i]] > topics[names[j]]
+	})
+
+	var b strings.Builder
+	fmt.Fprintf(&b, constants.DIGEST_HEADER, name, period, len(activities))
+	for _, topic := range names {
+		fmt.Fprintf(&b, "\n• %s %d ครั้ง", topic, topics[topic])
+	}
+
+	if len(scams) > 0 {
+		fmt.Fprintf(&b, "\n\n"+constants.DIGEST_SCAM_HEADER, len(scams))
+		for _, scam := range scams {
+			if scam.Kind == "image" {
+				fmt.Fprintf(&b, "\n• "+constants.DIGEST_IMAGE_SCAM, scam.Excerpt)
+			} else {
+				fmt.Fprintf(&b, "\n• %s", scam.Excerpt)
+			}
+		}
+	}
+
+	return b.String()
+}
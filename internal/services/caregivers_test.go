@@ -0,0 +1,81 @@
+package services
+
+import (
+	"context"
+	"larn-line/internal/models"
+	"larn-line/internal/rules"
+	"larn-line/internal/store"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/line/line-bot-sdk-go/v8/linebot/webhook"
+)
+
+func TestUnfollowRemovesLinks(t *testing.T) {
+	ctx := context.Background()
+	s := store.NewMemoryStore()
+	link := func(userId string, update func(user *models.User)) {
+		if err := s.UpdateUser(ctx, userId, func(user *models.User) error {
+			update(user)
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Uelder is looked after by Ucaregiver and looks after Uother.
+	link("Uelder", func(user *models.User) {
+		user.Caregivers, user.Elders = []string{"Ucaregiver"}, []string{"Uother"}
+	})
+	link("Ucaregiver", func(user *models.User) { user.Elders = []string{"Uelder"} })
+	link("Uother", func(user *models.User) { user.Caregivers = []string{"Uelder", "Ucaregiver"} })
+
+	app := newTestService(t, &recordingLarn{}, s)
+	app.handleEvent(webhook.UnfollowEvent{Source: webhook.UserSource{UserId: "Uelder"}})
+
+	if _, err := s.GetUser(ctx, "Uelder"); err != store.ErrNotFound {
+		t.Errorf("got %v, want the user deleted", err)
+	}
+
+	for userId, want := range map[string][]string{"Ucaregiver": {}, "Uother": {"Ucaregiver"}} {
+		user, err := s.GetUser(ctx, userId)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if slices.Contains(user.Elders, "Uelder") || slices.Contains(user.Caregivers, "Uelder") {
+			t.Errorf("%s is still linked to the deleted user: %+v", userId, user)
+		}
+		if len(want) > 0 && !slices.Equal(user.Caregivers, want) {
+			t.Errorf("%s has caregivers %q, want %q", userId, user.Caregivers, want)
+		}
+	}
+}
+
+func TestImageActivityQuotesTheVerdict(t *testing.T) {
+	ctx := context.Background()
+	s := store.NewMemoryStore()
+	app := newTestService(t, &recordingLarn{}, s)
+
+	app.recordActivity(ctx, rules.Event{
+		UserId:         "U1",
+		Kind:           "image",
+		Text:           imageHistoryText,
+		Classification: "scam",
+		Response:       "รูปนี้เป็นข้อความหลอกให้โอนเงินค่ะ",
+	})
+
+	activities, err := s.ListActivities(ctx, "U1", time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(activities) != 1 || activities[0].Excerpt != "รูปนี้เป็นข้อความหลอกให้โอนเงินค่ะ" {
+		t.Fatalf("got activities %+v, want the verdict quoted", activities)
+	}
+
+	text := app.digestText(ctx, "U1", activities, "วันนี้")
+	if strings.Contains(text, imageHistoryText) || !strings.Contains(text, "รูปนี้เป็นข้อความหลอกให้โอนเงินค่ะ") {
+		t.Errorf("digest %q does not quote the verdict", text)
+	}
+}
@@ -0,0 +1,241 @@
+package services
+
+import (
+	"larn-line/internal/constants"
+	"larn-line/internal/models"
+	"larn-line/internal/utils"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Command is a text message from a user on its way to a handler.
+type Command struct {
+	UserId     string
+	ReplyToken string
+	Text       string
+	// Group is set for messages in groups and rooms, where UserId is the
+	// chat's id.
+	Group bool
+	// Match holds the submatches when a pattern matched the text.
+	Match []string
+}
+
+type CommandHandler func(cmd Command) error
+
+// Route describes the texts a handler answers to.
+type Route struct {
+	Name string
+	// Phrases are the command and its aliases. They are compared after
+	// normalizing, so spacing, case and polite endings do not matter.
+	Phrases  []string
+	Patterns []*regexp.Regexp
+	// Typos is how many edits a text may be from a phrase and still match.
+	// Short phrases allow fewer so that ordinary questions are not taken
+	// for commands.
+	Typos int
+	// Routes with a higher priority are tried first.
+	Priority int
+	Handler  CommandHandler
+}
+
+type route struct {
+	Route
+	phrases []string
+}
+
+// CommandRouter picks the handler for a text message. Exact phrases and
+// patterns win over typo matches; anything left goes to the fallback.
+type CommandRouter struct {
+	routes   []*route
+	fallback CommandHandler
+}
+
+func NewCommandRouter(fallback CommandHandler) *CommandRouter {
+	return &CommandRouter{
+		fallback: fallback,
+	}
+}
+
+func (r *CommandRouter) Register(rt Route) {
+	phrases := make([]string, 0, len(rt.Phrases))
+	for _, phrase := range rt.Phrases {
+		phrases = append(phrases, normalizeCommand(phrase))
+	}
+
+	r.routes = append(r.routes, &route{
+		Route:   rt,
+		phrases: phrases,
+	})
+
+	sort.SliceStable(r.routes, func(i, j int) bool {
+		return r.routes[i].Priority > r.routes[j].Priority
+	})
+}
+
+func (r *CommandRouter) Dispatch(cmd Command) error {
+	rt, match := r.match(cmd.Text)
+	if rt == nil {
+		return r.fallback(cmd)
+	}
+
+	cmd.Match = match
+	return rt.Handler(cmd)
+}
+
+func (r *CommandRouter) match(text string) (*route, []string) {
+	normalized := normalizeCommand(text)
+
+	for _, rt := range r.routes {
+		if utils.Has(rt.phrases, normalized) {
+			return rt, nil
+		}
+		for _, pattern := range rt.Patterns {
+			if match := pattern.FindStringSubmatch(text); match != nil {
+				return rt, match
+			}
+		}
+	}
+
+	var best *route
+	bestDistance := 0
+	for _, rt := range r.routes {
+		for _, phrase := range rt.phrases {
+			allowed := min(rt.Typos, len([]rune(phrase))/5)
+			if allowed == 0 {
+				continue
+			}
+
+			distance := utils.EditDistance(normalized, phrase)
+			if distance <= allowed && (best == nil || distance < bestDistance) {
+				best, bestDistance = rt, distance
+			}
+		}
+	}
+
+	return best, nil
+}
+
+// politeEndings are dropped from the end of a text before matching, so
+// "อ่านต่อค่ะ" runs the same command as "อ่านต่อ".
+var politeEndings = []string{"นะคะ", "นะครับ", "ครับ", "ค่ะ", "คะ", "จ้า", "จ้ะ", "หน่อย"}
+
+func normalizeCommand(text string) string {
+	text = strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) || r == '\u200b' {
+			return -1
+		}
+		return unicode.ToLower(r)
+	}, text)
+
+	for _, ending := range politeEndings {
+		if trimmed := strings.TrimSuffix(text, ending); trimmed != "" {
+			text = trimmed
+		}
+	}
+
+	return text
+}
+
+// readAloudPattern matches constants.READ_ALOUD_ON and READ_ALOUD_OFF and
+// their shorter spoken forms.
+var readAloudPattern = regexp.MustCompile(`^\s*(เปิด|ปิด)\s*เสียง\s*(อ่าน)?\s*$`)
+
+func (app *LineService) registerCommands() {
+	app.commands.Register(Route{
+		Name:    "news_check",
+		Phrases: []string{constants.NEWS_CHECK, "เช็คข่าว", "ตรวจข่าว"},
+		Typos:   2,
+		Handler: func(cmd Command) error {
+			app.sendNewsTut(cmd.UserId, cmd.ReplyToken)
+			return nil
+		},
+	})
+	app.commands.Register(Route{
+		Name:    "read_more",
+		Phrases: []string{constants.READ_MORE, "ดูต่อ", "ขออ่านต่อ"},
+		Typos:   1,
+		Handler: func(cmd Command) error {
+			return app.sendPage(cmd.UserId, "", 0, cmd.ReplyToken)
+		},
+	})
+	app.commands.Register(Route{
+		Name:     "read_aloud",
+		Patterns: []*regexp.Regexp{readAloudPattern},
+		Handler: func(cmd Command) error {
+			return app.setReadAloud(cmd.UserId, cmd.Match[1] == "เปิด", cmd.ReplyToken)
+		},
+	})
+	app.commands.Register(Route{
+		Name:    "call_larn",
+		Phrases: []string{constants.CALL_LARN, "คุยกับหลาน", "ขอคุยกับคน"},
+		Typos:   1,
+		Handler: app.privateOnly(func(cmd Command) error {
+			return app.offerVolunteer(cmd.UserId, cmd.ReplyToken)
+		}),
+	})
+	app.commands.Register(Route{
+		Name:    "talk_to_operator",
+		Phrases: []string{constants.TALK_TO_OPERATOR, "คุยกับเจ้าหน้าที่", "ขอคุยกับเจ้าหน้าที่"},
+		Typos:   2,
+		Handler: app.privateOnly(func(cmd Command) error {
+			return app.startHumanMode(cmd.UserId, cmd.ReplyToken)
+		}),
+	})
+	app.commands.Register(Route{
+		Name:    "link_family",
+		Phrases: []string{constants.LINK_FAMILY, "เชื่อมกับลูกหลาน", "เชื่อมต่อครอบครัว"},
+		Typos:   2,
+		Handler: app.privateOnly(func(cmd Command) error {
+			return app.askLinkConsent(cmd.UserId, cmd.ReplyToken)
+		}),
+	})
+	app.commands.Register(Route{
+		Name:     "link_code",
+		Patterns: []*regexp.Regexp{linkCodePattern},
+		Handler: app.privateOnly(func(cmd Command) error {
+			return app.linkCaregiver(cmd.UserId, cmd.Match[1], cmd.ReplyToken)
+		}),
+	})
+	app.commands.Register(Route{
+		Name:    "unlink_family",
+		Phrases: []string{constants.UNLINK_FAMILY, "ยกเลิกการเชื่อมต่อลูกหลาน"},
+		Typos:   2,
+		Handler: app.privateOnly(func(cmd Command) error {
+			return app.unlink(cmd.UserId, cmd.ReplyToken)
+		}),
+	})
+	app.commands.Register(Route{
+		Name:    "new_topic",
+		Phrases: []string{constants.NEW_TOPIC, "เปลี่ยนเรื่อง", "เริ่มใหม่"},
+		Typos:   2,
+		Handler: func(cmd Command) error {
+			return app.startNewTopic(cmd.UserId, cmd.ReplyToken)
+		},
+	})
+	app.commands.Register(Route{
+		Name:    "previous_topics",
+		Phrases: []string{constants.PREVIOUS_TOPICS, "คุยเรื่องเดิม", "เรื่องเก่า"},
+		Typos:   2,
+		Handler: func(cmd Command) error {
+			return app.listPreviousTopics(cmd.UserId, cmd.ReplyToken)
+		},
+	})
+	app.commands.Register(Route{
+		Name:    "digest_daily",
+		Phrases: []string{constants.DIGEST_DAILY},
+		Typos:   1,
+		Handler: app.privateOnly(func(cmd Command) error {
+			return app.setDigest(cmd.UserId, models.DigestDaily, cmd.ReplyToken)
+		}),
+	})
+	app.commands.Register(Route{
+		Name:    "digest_weekly",
+		Phrases: []string{constants.DIGEST_WEEKLY},
+		Typos:   1,
+		Handler: app.privateOnly(func(cmd Command) error {
+			return app.setDigest(cmd.UserId, models.DigestWeekly, cmd.ReplyToken)
+		}),
+	})
+}
@@ -0,0 +1,56 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/line/line-bot-sdk-go/v8/linebot/webhook"
+)
+
+// maxContentSize caps downloads of user-sent media.
+const maxContentSize = 10 << 20
+
+var contentClient = &http.Client{
+	Timeout: 30 * time.Second,
+}
+
+// getMessageContent downloads the media of a message, either from LINE or
+// from the external provider it was sent through.
+func (app *LineService) getMessageContent(ctx context.Context, messageId string, provider *webhook.ContentProvider) ([]byte, string, error) {
+	var res *http.Response
+	var err error
+
+	if provider != nil && provider.Type == webhook.ContentProviderTYPE_EXTERNAL {
+		req, reqErr := http.NewRequestWithContext(ctx, "GET", provider.OriginalContentUrl, nil)
+		if reqErr != nil {
+			return nil, "", upstreamError("get message content", reqErr)
+		}
+		res, err = contentClient.Do(req)
+	} else {
+		res, err = app.blob.GetMessageContent(messageId)
+	}
+
+	if err != nil {
+		return nil, "", upstreamError("get message content", err)
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode != 200 {
+		return nil, "", upstreamError("get message content", fmt.Errorf("status %d", res.StatusCode))
+	}
+
+	content, err := io.ReadAll(io.LimitReader(res.Body, maxContentSize+1))
+	if err != nil {
+		return nil, "", upstreamError("read message content", err)
+	}
+
+	if len(content) > maxContentSize {
+		return nil, "", upstreamError("read message content", fmt.Errorf("content larger than %d bytes", maxContentSize))
+	}
+
+	return content, res.Header.Get("Content-Type"), nil
+}
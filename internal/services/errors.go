@@ -0,0 +1,65 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+)
+
+type ErrorKind int
+
+const (
+	// KindStorage covers failures reading or writing the store.
+	KindStorage ErrorKind = iota + 1
+	// KindUpstream covers failures talking to the Larn API.
+	KindUpstream
+	// KindRendering covers responses that cannot be turned into LINE messages.
+	KindRendering
+)
+
+func (k ErrorKind) String() string {
+	switch k {
+	case KindStorage:
+		return "storage"
+	case KindUpstream:
+		return "upstream"
+	case KindRendering:
+		return "rendering"
+	default:
+		return "unknown"
+	}
+}
+
+type Error struct {
+	Kind ErrorKind
+	Op   string
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s: %v", e.Kind, e.Op, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func storageError(op string, err error) error {
+	return &Error{Kind: KindStorage, Op: op, Err: err}
+}
+
+func upstreamError(op string, err error) error {
+	return &Error{Kind: KindUpstream, Op: op, Err: err}
+}
+
+func renderingError(op string, err error) error {
+	return &Error{Kind: KindRendering, Op: op, Err: err}
+}
+
+// ErrorKindOf returns the kind of the first *Error in err's chain, or 0.
+func ErrorKindOf(err error) ErrorKind {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Kind
+	}
+	return 0
+}
@@ -0,0 +1,58 @@
+package services
+
+import "github.com/line/line-bot-sdk-go/v8/linebot/webhook"
+
+type eventMeta struct {
+	source         webhook.SourceInterface
+	webhookEventId string
+	redelivery     bool
+}
+
+func newEventMeta(source webhook.SourceInterface, webhookEventId string, delivery *webhook.DeliveryContext) eventMeta {
+	return eventMeta{
+		source:         source,
+		webhookEventId: webhookEventId,
+		redelivery:     delivery != nil && delivery.IsRedelivery,
+	}
+}
+
+// metaOf extracts the fields shared by the webhook events the bot handles.
+// The SDK's EventInterface only exposes GetType, so each event type is
+// matched explicitly.
+func metaOf(event webhook.EventInterface) eventMeta {
+	switch e := event.(type) {
+	case webhook.MessageEvent:
+		return newEventMeta(e.Source, e.WebhookEventId, e.DeliveryContext)
+	case webhook.FollowEvent:
+		return newEventMeta(e.Source, e.WebhookEventId, e.DeliveryContext)
+	case webhook.UnfollowEvent:
+		return newEventMeta(e.Source, e.WebhookEventId, e.DeliveryContext)
+	case webhook.PostbackEvent:
+		return newEventMeta(e.Source, e.WebhookEventId, e.DeliveryContext)
+	case webhook.JoinEvent:
+		return newEventMeta(e.Source, e.WebhookEventId, e.DeliveryContext)
+	case webhook.LeaveEvent:
+		return newEventMeta(e.Source, e.WebhookEventId, e.DeliveryContext)
+	case webhook.MemberJoinedEvent:
+		return newEventMeta(e.Source, e.WebhookEventId, e.DeliveryContext)
+	case webhook.MemberLeftEvent:
+		return newEventMeta(e.Source, e.WebhookEventId, e.DeliveryContext)
+	default:
+		return eventMeta{}
+	}
+}
+
+// sourceKey identifies the chat an event belongs to: the group or room for
+// multi-person chats, otherwise the user.
+func sourceKey(source webhook.SourceInterface) string {
+	switch s := source.(type) {
+	case webhook.UserSource:
+		return s.UserId
+	case webhook.GroupSource:
+		return s.GroupId
+	case webhook.RoomSource:
+		return s.RoomId
+	default:
+		return ""
+	}
+}
@@ -2,7 +2,6 @@ package services
 
 import (
 	"context"
-	"log"
 	"os"
 
 	"cloud.google.com/go/firestore"
@@ -20,7 +19,7 @@ func NewFirestore() (*firestore.Client, error) {
 
 	firestore, err := app.Firestore(ctx)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 
 	return firestore, nil
@@ -0,0 +1,177 @@
+package services
+
+import (
+	"context"
+	"larn-line/internal/constants"
+	"log"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/line/line-bot-sdk-go/v8/linebot/messaging_api"
+	"github.com/line/line-bot-sdk-go/v8/linebot/webhook"
+)
+
+// botUser caches the bot's own user id, which mentions of the bot carry.
+type botUser struct {
+	mu sync.Mutex
+	id string
+}
+
+func (app *LineService) botUserId() string {
+	app.self.mu.Lock()
+	defer app.self.mu.Unlock()
+
+	if app.self.id == "" {
+		info, err := app.bot.GetBotInfo()
+		if err != nil {
+			log.Printf("Cannot get bot info: %+v\n", err)
+			return ""
+		}
+		app.self.id = info.UserId
+	}
+
+	return app.self.id
+}
+
+// addressedText returns what a group message asks the bot, when it
+// mentions the bot or starts with one of the group prefixes. The mention or
+// prefix itself is left out.
+func (app *LineService) addressedText(message webhook.TextMessageContent) (string, bool) {
+	if message.Mention != nil {
+		if self := app.botUserId(); self != "" {
+			// Mentions are located in UTF-16 code units.
+			text := utf16.Encode([]rune(message.Text))
+			for _, mentionee := range message.Mention.Mentionees {
+				m, ok := mentionee.(webhook.UserMentionee)
+				if !ok || m.UserId != self {
+					continue
+				}
+
+				start, end := int(m.Index), int(m.Index+m.Length)
+				if start < 0 || end > len(text) || start > end {
+					continue
+				}
+				rest := append(append([]uint16{}, text[:start]...), text[end:]...)
+				return strings.Join(strings.Fields(string(utf16.Decode(rest))), " "), true
+			}
+		}
+	}
+
+	// Thai runs words together, so the prefix has to stand apart for
+	// "หลานเองตอบผิดนะ", said about the bot, not to be taken as a question.
+	text := strings.TrimSpace(message.Text)
+	for _, prefix := range app.groupPrefixes {
+		if len(text) < len(prefix) || !strings.EqualFold(text[:len(prefix)], prefix) {
+			continue
+		}
+
+		rest := text[len(prefix):]
+		if r, _ := utf8.DecodeRuneInString(rest); rest != "" && !unicode.IsSpace(r) && !unicode.IsPunct(r) {
+			continue
+		}
+		return strings.TrimSpace(strings.TrimLeftFunc(rest, unicode.IsPunct)), true
+	}
+
+	return "", false
+}
+
+// handleGroupMessage answers a group or room when it is spoken to. The
+// conversation is kept under the chat's id, so members share its history.
+// Images and voice messages cannot address the bot and are ignored.
+func (app *LineService) handleGroupMessage(chatId string, e webhook.MessageEvent) error {
+	message, ok := e.Message.(webhook.TextMessageContent)
+	if !ok {
+		return nil
+	}
+
+	text, addressed := app.addressedText(message)
+	if !addressed {
+		return nil
+	}
+
+	if text == "" {
+		app.replyChatText(chatId, e.ReplyToken, constants.GROUP_HELP_MESSAGE)
+		return nil
+	}
+
+	return app.commands.Dispatch(Command{
+		UserId:     chatId,
+		ReplyToken: e.ReplyToken,
+		Text:       text,
+		Group:      true,
+	})
+}
+
+func (app *LineService) joinGroup(chatId string, replyToken string) {
+	if err := app.createUserIfNotExist(chatId); err != nil {
+		log.Printf("Cannot create chat %s: %+v\n", chatId, err)
+	}
+
+	app.replyChatText(chatId, replyToken, constants.GROUP_JOIN_MESSAGE)
+}
+
+// leaveGroup forgets the chat's conversation once the bot is removed.
+func (app *LineService) leaveGroup(chatId string) {
+	if err := app.store.DeleteUser(context.Background(), chatId); err != nil {
+		log.Printf("Cannot delete chat %s: %+v\n", chatId, err)
+	}
+}
+
+// isGroupChat reports whether chatId is a group's or a room's rather than a
+// user's. LINE gives users ids starting with U, groups C and rooms R.
+func isGroupChat(chatId string) bool {
+	return strings.HasPrefix(chatId, "C") || strings.HasPrefix(chatId, "R")
+}
+
+// addressed returns quickReply as it can be offered in the chat. A quick
+// reply tapped in a group sends its text, which the bot ignores unless it
+// is addressed, so there the text gets the first group prefix. Without
+// prefixes such quick replies are left out.
+func (app *LineService) addressed(chatId string, quickReply *messaging_api.QuickReply) *messaging_api.QuickReply {
+	if quickReply == nil || !isGroupChat(chatId) {
+		return quickReply
+	}
+
+	items := make([]messaging_api.QuickReplyItem, 0, len(quickReply.Items))
+	for _, item := range quickReply.Items {
+		if action, ok := item.Action.(*messaging_api.MessageAction); ok {
+			if len(app.groupPrefixes) == 0 {
+				continue
+			}
+			item.Action = &messaging_api.MessageAction{
+				Action: messaging_api.Action{Type: "message"},
+				Label:  action.Label,
+				Text:   app.groupPrefixes[0] + " " + action.Text,
+			}
+		}
+		items = append(items, item)
+	}
+
+	return &messaging_api.QuickReply{Items: items}
+}
+
+// quickRepliesFor returns the default quick replies as offered in the chat.
+func (app *LineService) quickRepliesFor(chatId string) *messaging_api.QuickReply {
+	return app.addressed(chatId, app.quickReplies)
+}
+
+// handleGroupError is handleError for a group or room.
+func (app *LineService) handleGroupError(chatId string, replyToken string, err error) {
+	log.Printf("Cannot handle message in %s: %+v\n", chatId, err)
+	app.replyChatText(chatId, replyToken, errorText(err))
+}
+
+// privateOnly keeps a command that concerns one person, such as linking a
+// caregiver, out of groups and rooms.
+func (app *LineService) privateOnly(handler CommandHandler) CommandHandler {
+	return func(cmd Command) error {
+		if cmd.Group {
+			app.replyChatText(cmd.UserId, cmd.ReplyToken, constants.PRIVATE_ONLY_MESSAGE)
+			return nil
+		}
+		return handler(cmd)
+	}
+}
@@ -0,0 +1,82 @@
+package services
+
+import (
+	"larn-line/internal/utils"
+	"testing"
+
+	"github.com/line/line-bot-sdk-go/v8/linebot/messaging_api"
+	"github.com/line/line-bot-sdk-go/v8/linebot/webhook"
+)
+
+func TestAddressedText(t *testing.T) {
+	app := &LineService{groupPrefixes: []string{"หลานเอง"}}
+	app.self.id = "Ubot"
+
+	mention := func(text string, index int32, length int32, userId string) webhook.TextMessageContent {
+		return webhook.TextMessageContent{
+			Text: text,
+			Mention: &webhook.Mention{
+				Mentionees: []webhook.MentioneeInterface{
+					webhook.UserMentionee{Index: index, Length: length, UserId: userId},
+				},
+			},
+		}
+	}
+
+	tests := []struct {
+		name      string
+		message   webhook.TextMessageContent
+		want      string
+		addressed bool
+	}{
+		{"prefix", webhook.TextMessageContent{Text: "หลานเอง ช่วยด้วย"}, "ช่วยด้วย", true},
+		{"prefix alone", webhook.TextMessageContent{Text: "หลานเอง"}, "", true},
+		{"prefix and comma", webhook.TextMessageContent{Text: "หลานเอง, ช่วยด้วย"}, "ช่วยด้วย", true},
+		{"prefix within a word", webhook.TextMessageContent{Text: "หลานเองตอบผิดนะ"}, "", false},
+		{"not addressed", webhook.TextMessageContent{Text: "สวัสดีทุกคน"}, "", false},
+		// Offsets are in UTF-16 code units, which the emoji takes two of.
+		{"mention", mention("😀 @หลานเอง วิธีถ่ายภาพหน้าจอ", 3, 8, "Ubot"), "😀 วิธีถ่ายภาพหน้าจอ", true},
+		{"mention of someone else", mention("@แม่ กินข้าวยัง", 0, 4, "Umom"), "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, addressed := app.addressedText(tt.message)
+			if got != tt.want || addressed != tt.addressed {
+				t.Errorf("addressedText(%q) = %q, %t, want %q, %t", tt.message.Text, got, addressed, tt.want, tt.addressed)
+			}
+		})
+	}
+}
+
+func TestAddressedQuickReplies(t *testing.T) {
+	app := &LineService{groupPrefixes: []string{"หลานเอง"}}
+
+	quickReply := utils.CreateQuickReply([]string{"ลบแอปพลิเคชัน"})
+	quickReply.Items = append(quickReply.Items, messaging_api.QuickReplyItem{
+		Action: PostbackAction("อ่านต่อ", "อ่านต่อ", readMoreAction, nil),
+	})
+
+	if got := app.addressed("U1", quickReply); got != quickReply {
+		t.Error("quick replies for a user were changed")
+	}
+
+	got := app.addressed("C1", quickReply)
+	if len(got.Items) != 2 {
+		t.Fatalf("got %d items, want 2", len(got.Items))
+	}
+	if action := got.Items[0].Action.(*messaging_api.MessageAction); action.Text != "หลานเอง ลบแอปพลิเคชัน" || action.Label != "ลบแอปพลิเคชัน" {
+		t.Errorf("got message action %+v, want the text prefixed", action)
+	}
+	if _, ok := got.Items[1].Action.(*messaging_api.PostbackAction); !ok {
+		t.Errorf("got %T, want the postback kept", got.Items[1].Action)
+	}
+	if action := quickReply.Items[0].Action.(*messaging_api.MessageAction); action.Text != "ลบแอปพลิเคชัน" {
+		t.Error("the original quick replies were changed")
+	}
+
+	app.groupPrefixes = nil
+	if got := app.addressed("R1", quickReply); len(got.Items) != 1 {
+		t.Errorf("got %d items without prefixes, want only the postback", len(got.Items))
+	}
+}
@@ -0,0 +1,134 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"larn-line/internal/constants"
+	"larn-line/internal/models"
+	"larn-line/internal/utils"
+	"log"
+	"net/url"
+	"time"
+
+	"github.com/line/line-bot-sdk-go/v8/linebot/messaging_api"
+)
+
+const acceptHandoffAction = "accept_handoff"
+
+var errStaleHandoff = errors.New("handoff was not offered")
+
+// offerVolunteer picks a volunteer on duty and offers the user to talk to
+// them. The offer is recorded on the user so that accepting it can be
+// checked and counted.
+func (app *LineService) offerVolunteer(userId string, replyToken string) error {
+	now := time.Now()
+
+	if app.roster == nil {
+		app.replyText(replyToken, constants.NO_VOLUNTEER_MESSAGE)
+		return nil
+	}
+
+	volunteer, ok := app.roster.Pick(now)
+	if !ok {
+		app.replyText(replyToken, constants.NO_VOLUNTEER_MESSAGE)
+		return nil
+	}
+
+	if err := app.store.UpdateUser(context.Background(), userId, func(user *models.User) error {
+		user.Handoff = &models.Handoff{
+			VolunteerId: volunteer.Id,
+			OfferedAt:   now,
+		}
+		return nil
+	}); err != nil {
+		return storageError("offer handoff", err)
+	}
+
+	bubble := &messaging_api.FlexBubble{
+		Body: &messaging_api.FlexBox{
+			Layout:  messaging_api.FlexBoxLAYOUT_VERTICAL,
+			Spacing: "md",
+			Contents: []messaging_api.FlexComponentInterface{
+				&messaging_api.FlexText{
+					Text:   constants.CALL_LARN,
+					Size:   "xl",
+					Weight: messaging_api.FlexTextWEIGHT_BOLD,
+				},
+				&messaging_api.FlexText{
+					Text: "หลาน" + volunteer.Name + " " + constants.VOLUNTEER_READY_MESSAGE,
+					Size: "lg",
+					Wrap: true,
+				},
+			},
+		},
+		Footer: &messaging_api.FlexBox{
+			Layout: messaging_api.FlexBoxLAYOUT_VERTICAL,
+			Contents: []messaging_api.FlexComponentInterface{
+				&messaging_api.FlexButton{
+					Action: PostbackAction(constants.ACCEPT_HANDOFF, constants.ACCEPT_HANDOFF, acceptHandoffAction,
+						url.Values{"volunteer": {volunteer.Id}}),
+					Style:  messaging_api.FlexButtonSTYLE_PRIMARY,
+					Height: messaging_api.FlexButtonHEIGHT_MD,
+				},
+			},
+		},
+	}
+
+	if _, err := app.bot.ReplyMessage(
+		&messaging_api.ReplyMessageRequest{
+			ReplyToken: replyToken,
+			Messages: []messaging_api.MessageInterface{
+				&messaging_api.FlexMessage{
+					AltText:    constants.CALL_LARN,
+					Contents:   bubble,
+					QuickReply: app.quickReplies,
+				},
+			},
+		},
+	); err != nil {
+		log.Print(err)
+	}
+
+	return nil
+}
+
+// acceptHandoff records that the user took up the offer and sends them the
+// volunteer's account. Offers that were replaced by a newer one, or whose
+// volunteer left the roster, are offered again instead.
+func (app *LineService) acceptHandoff(userId string, volunteerId string, replyToken string) error {
+	if app.roster == nil {
+		return app.offerVolunteer(userId, replyToken)
+	}
+
+	volunteer, ok := app.roster.Get(volunteerId)
+	now := time.Now()
+
+	err := app.store.UpdateUser(context.Background(), userId, func(user *models.User) error {
+		if !ok || user.Handoff == nil || user.Handoff.VolunteerId != volunteerId {
+			return errStaleHandoff
+		}
+		user.Handoff.AcceptedAt = &now
+		return nil
+	})
+	if errors.Is(err, errStaleHandoff) {
+		return app.offerVolunteer(userId, replyToken)
+	}
+	if err != nil {
+		return storageError("accept handoff", err)
+	}
+
+	app.roster.Accepted(volunteerId, now)
+
+	if _, err := app.bot.ReplyMessage(
+		&messaging_api.ReplyMessageRequest{
+			ReplyToken: replyToken,
+			Messages: []messaging_api.MessageInterface{
+				utils.CreateCallLarnMessage(volunteer.Url),
+			},
+		},
+	); err != nil {
+		log.Print(err)
+	}
+
+	return nil
+}
@@ -0,0 +1,228 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"larn-line/internal/constants"
+	"larn-line/internal/models"
+	"larn-line/internal/store"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/line/line-bot-sdk-go/v8/linebot/messaging_api"
+	"github.com/line/line-bot-sdk-go/v8/linebot/webhook"
+)
+
+// OperatorEvent is sent to operators watching conversations live.
+type OperatorEvent struct {
+	// Type is "message" or "mode".
+	Type      string    `json:"type"`
+	UserId    string    `json:"userId"`
+	From      string    `json:"from,omitempty"`
+	Text      string    `json:"text,omitempty"`
+	Mode      string    `json:"mode,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// operatorHub fans events out to every connected operator. Operators that
+// fall behind miss events rather than hold up the bot.
+type operatorHub struct {
+	mu          sync.Mutex
+	subscribers map[chan OperatorEvent]struct{}
+}
+
+func newOperatorHub() *operatorHub {
+	return &operatorHub{
+		subscribers: make(map[chan OperatorEvent]struct{}),
+	}
+}
+
+func (h *operatorHub) subscribe() chan OperatorEvent {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	ch := make(chan OperatorEvent, 32)
+	h.subscribers[ch] = struct{}{}
+	return ch
+}
+
+func (h *operatorHub) unsubscribe(ch chan OperatorEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	delete(h.subscribers, ch)
+}
+
+func (h *operatorHub) publish(event OperatorEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for ch := range h.subscribers {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+// isHumanMode reports whether an operator is answering userId. When the
+// user cannot be read the bot answers, so that nobody is left unanswered.
+func (app *LineService) isHumanMode(userId string) bool {
+	user, err := app.store.GetUser(context.Background(), userId)
+	if err != nil {
+		if !errors.Is(err, store.ErrNotFound) {
+			log.Printf("Cannot get user %s: %+v\n", userId, err)
+		}
+		return false
+	}
+	return user.Mode == models.ModeHuman
+}
+
+// errNotHumanMode is returned when handing back a conversation no operator
+// is answering.
+var errNotHumanMode = errors.New("conversation is not in human mode")
+
+func (app *LineService) setMode(userId string, mode string) error {
+	now := time.Now()
+
+	if err := app.store.UpdateUser(context.Background(), userId, func(user *models.User) error {
+		user.Mode = mode
+		user.ModeChangedAt = now
+		return nil
+	}); err != nil {
+		return storageError("update mode", err)
+	}
+
+	app.publishMode(userId, mode, now)
+	return nil
+}
+
+// endHumanMode hands the conversation back to the bot if an operator is
+// answering it, checking and changing the mode in one update.
+func (app *LineService) endHumanMode(userId string) error {
+	now := time.Now()
+
+	if err := app.store.UpdateUser(context.Background(), userId, func(user *models.User) error {
+		if user.Mode != models.ModeHuman {
+			return errNotHumanMode
+		}
+		user.Mode = models.ModeBot
+		user.ModeChangedAt = now
+		return nil
+	}); err != nil {
+		if errors.Is(err, errNotHumanMode) {
+			return err
+		}
+		return storageError("update mode", err)
+	}
+
+	app.publishMode(userId, models.ModeBot, now)
+	return nil
+}
+
+func (app *LineService) publishMode(userId string, mode string, now time.Time) {
+	app.operators.publish(OperatorEvent{
+		Type:      "mode",
+		UserId:    userId,
+		Mode:      mode,
+		Timestamp: now,
+	})
+}
+
+func (app *LineService) startHumanMode(userId string, replyToken string) error {
+	if err := app.setMode(userId, models.ModeHuman); err != nil {
+		return err
+	}
+
+	if _, err := app.bot.ReplyMessage(
+		&messaging_api.ReplyMessageRequest{
+			ReplyToken: replyToken,
+			Messages: []messaging_api.MessageInterface{
+				&messaging_api.TextMessage{
+					Text: constants.HUMAN_MODE_MESSAGE,
+					QuickReply: &messaging_api.QuickReply{
+						Items: []messaging_api.QuickReplyItem{
+							{
+								Action: &messaging_api.MessageAction{
+									Label: constants.BACK_TO_BOT,
+									Text:  constants.BACK_TO_BOT,
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	); err != nil {
+		log.Print(err)
+	}
+
+	return nil
+}
+
+func (app *LineService) returnToBot(userId string, replyToken string) error {
+	if err := app.setMode(userId, models.ModeBot); err != nil {
+		return err
+	}
+
+	app.replyText(replyToken, constants.BOT_MODE_MESSAGE)
+	return nil
+}
+
+// handleHumanMessage keeps a message for the operator instead of asking
+// Larn. The operator answers with a push message, so nothing is replied.
+func (app *LineService) handleHumanMessage(userId string, content webhook.MessageContentInterface, replyToken string) error {
+	var text string
+	switch message := content.(type) {
+	case webhook.TextMessageContent:
+		if normalizeCommand(message.Text) == normalizeCommand(constants.BACK_TO_BOT) {
+			return app.returnToBot(userId, replyToken)
+		}
+		text = message.Text
+	case webhook.ImageMessageContent:
+		text = constants.HUMAN_MODE_IMAGE
+	case webhook.AudioMessageContent:
+		text = constants.HUMAN_MODE_AUDIO
+	default:
+		log.Printf("Unsupported message content: %T\n", content)
+		return nil
+	}
+
+	ctx := context.Background()
+
+	sessionId, err := app.currentSession(ctx, userId)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	if err := app.store.AddHistory(ctx, userId, sessionId, models.History{
+		From:      "user",
+		Message:   text,
+		Timestamp: now,
+	}); err != nil {
+		return storageError("save message", err)
+	}
+
+	app.operators.publish(OperatorEvent{
+		Type:      "message",
+		UserId:    userId,
+		From:      "user",
+		Text:      text,
+		Timestamp: now,
+	})
+
+	return nil
+}
+
+// larnHistory presents operator replies to Larn as its own answers, which
+// is how the user saw them.
+func larnHistory(histories []models.History) []models.History {
+	for i, history := range histories {
+		if history.From == "operator" {
+			histories[i].From = "model"
+		}
+	}
+	return histories
+}
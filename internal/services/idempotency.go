@@ -0,0 +1,141 @@
+package services
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// IdempotencyStore remembers which webhook events were already handled so
+// redeliveries from LINE are not processed twice.
+type IdempotencyStore interface {
+	// MarkSeen records id and reports whether it had been recorded before.
+	MarkSeen(ctx context.Context, id string) (bool, error)
+	// Forget removes id, so that a redelivery of the event is handled.
+	Forget(ctx context.Context, id string) error
+}
+
+type memoryIdempotencyStore struct {
+	mu       sync.Mutex
+	capacity int
+	ttl      time.Duration
+	order    *list.List
+	entries  map[string]*list.Element
+}
+
+type seenEvent struct {
+	id       string
+	expireAt time.Time
+}
+
+// NewMemoryIdempotencyStore keeps up to capacity event ids for ttl, evicting
+// the least recently seen first.
+func NewMemoryIdempotencyStore(capacity int, ttl time.Duration) IdempotencyStore {
+	return &memoryIdempotencyStore{
+		capacity: capacity,
+		ttl:      ttl,
+		order:    list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+}
+
+func (s *memoryIdempotencyStore) MarkSeen(ctx context.Context, id string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+
+	if el, ok := s.entries[id]; ok {
+		entry := el.Value.(*seenEvent)
+		if now.Before(entry.expireAt) {
+			s.order.MoveToFront(el)
+			return true, nil
+		}
+		s.order.Remove(el)
+		delete(s.entries, id)
+	}
+
+	s.entries[id] = s.order.PushFront(&seenEvent{
+		id:       id,
+		expireAt: now.Add(s.ttl),
+	})
+
+	for s.order.Len() > s.capacity {
+		oldest := s.order.Back()
+		s.order.Remove(oldest)
+		delete(s.entries, oldest.Value.(*seenEvent).id)
+	}
+
+	return false, nil
+}
+
+func (s *memoryIdempotencyStore) Forget(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if el, ok := s.entries[id]; ok {
+		s.order.Remove(el)
+		delete(s.entries, id)
+	}
+
+	return nil
+}
+
+type firestoreIdempotencyStore struct {
+	firestore *firestore.Client
+	ttl       time.Duration
+}
+
+// NewFirestoreIdempotencyStore stores event ids in the webhook_events
+// collection. Each document carries an expireAt field, which a Firestore TTL
+// policy can use to clean the collection up.
+func NewFirestoreIdempotencyStore(client *firestore.Client, ttl time.Duration) IdempotencyStore {
+	return &firestoreIdempotencyStore{
+		firestore: client,
+		ttl:       ttl,
+	}
+}
+
+func (s *firestoreIdempotencyStore) MarkSeen(ctx context.Context, id string) (bool, error) {
+	doc := s.firestore.Collection("webhook_events").Doc(id)
+	seen := false
+
+	err := s.firestore.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		seen = false
+
+		snap, err := tx.Get(doc)
+		if err != nil && status.Code(err) != codes.NotFound {
+			return err
+		}
+
+		now := time.Now()
+
+		// TTL policies delete lazily, so an expired document may still exist.
+		if snap != nil && snap.Exists() {
+			if expireAt, ok := snap.Data()["expireAt"].(time.Time); ok && now.Before(expireAt) {
+				seen = true
+				return nil
+			}
+		}
+
+		return tx.Set(doc, map[string]any{
+			"expireAt": now.Add(s.ttl),
+		})
+	})
+
+	if err != nil {
+		return false, err
+	}
+
+	return seen, nil
+}
+
+func (s *firestoreIdempotencyStore) Forget(ctx context.Context, id string) error {
+	_, err := s.firestore.Collection("webhook_events").Doc(id).Delete(ctx)
+	return err
+}
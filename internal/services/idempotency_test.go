@@ -0,0 +1,52 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"larn-line/internal/models"
+	"larn-line/internal/store"
+	"testing"
+	"time"
+
+	"github.com/line/line-bot-sdk-go/v8/linebot/webhook"
+)
+
+// flakyLarn fails its first question as the Larn API would, then answers.
+type flakyLarn struct {
+	recordingLarn
+	failed bool
+}
+
+func (l *flakyLarn) Message(ctx context.Context, message string, summary string, history []models.History) (*models.Message, error) {
+	if !l.failed {
+		l.failed = true
+		return nil, upstreamError("get larn message", errors.New("timeout"))
+	}
+	return l.recordingLarn.Message(ctx, message, summary, history)
+}
+
+func TestFailedEventIsHandledOnRedelivery(t *testing.T) {
+	larn := &flakyLarn{}
+	app := newTestService(t, larn, store.NewMemoryStore())
+	app.idempotency = NewMemoryIdempotencyStore(10, time.Hour)
+
+	event := webhook.MessageEvent{
+		Source:         webhook.UserSource{UserId: "U1"},
+		WebhookEventId: "E1",
+		ReplyToken:     "reply",
+		Message:        webhook.TextMessageContent{Text: "hello"},
+	}
+
+	// The first delivery fails, the redelivery is answered and any further
+	// redelivery is a duplicate.
+	for range 3 {
+		app.handleEvent(event)
+	}
+
+	if len(larn.asked) != 1 {
+		t.Fatalf("answered %d times, want 1", len(larn.asked))
+	}
+	if got := app.duplicates.Load(); got != 1 {
+		t.Fatalf("got %d duplicates, want 1", got)
+	}
+}
@@ -0,0 +1,57 @@
+package services
+
+import (
+	"context"
+	"encoding/base64"
+	"larn-line/internal/models"
+
+	"github.com/line/line-bot-sdk-go/v8/linebot/webhook"
+)
+
+// imageHistoryText stands in for a screenshot in the conversation history,
+// which only holds text.
+const imageHistoryText = "(ส่งรูปภาพเพื่อตรวจสอบความปลอดภัย)"
+
+func (l *httpLarnClient) CheckImage(ctx context.Context, image []byte, contentType string) (*models.Message, error) {
+	payload := map[string]any{
+		"image":    base64.StdEncoding.EncodeToString(image),
+		"mimeType": contentType,
+	}
+
+	var response models.Message
+
+	if err := l.post(ctx, "/ai/scam-check", payload, &response); err != nil {
+		return nil, upstreamError("check image", err)
+	}
+
+	return &response, nil
+}
+
+// handleImageMessage checks a screenshot for scams and replies with the
+// verdict, rendered the same way as a text answer.
+func (app *LineService) handleImageMessage(userId string, message webhook.ImageMessageContent, replyToken string) error {
+	ctx := context.Background()
+
+	image, contentType, err := app.getMessageContent(ctx, message.Id, message.ContentProvider)
+	if err != nil {
+		return err
+	}
+
+	release := app.turns.lock(userId)
+	handedOff := false
+	defer func() {
+		if !handedOff {
+			release()
+		}
+	}()
+
+	res, err := app.larn.CheckImage(ctx, image, contentType)
+	if err != nil {
+		return err
+	}
+
+	handedOff = true
+	go app.finishTurn(ctx, userId, "image", imageHistoryText, res, release)
+
+	return app.replyLarnResponse(ctx, userId, replyToken, res)
+}
@@ -2,60 +2,163 @@ package services
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"larn-line/internal/models"
-	"log"
 	"net/http"
-	"os"
+	"strings"
+	"time"
 )
 
-func GetLarn(message string, history []models.History) (*models.Message, error) {
+type LarnClient interface {
+	// Message answers message given the recent history and a summary of
+	// the turns before it, which may be empty.
+	Message(ctx context.Context, message string, summary string, history []models.History) (*models.Message, error)
+	// StreamMessage is Message with the answer handed to onText part by
+	// part as it is written.
+	StreamMessage(ctx context.Context, message string, summary string, history []models.History, onText func(text string)) (*models.Message, error)
+	Recommend(ctx context.Context, message string) ([]string, error)
+	// CheckImage asks Larn whether a screenshot shows a scam.
+	CheckImage(ctx context.Context, image []byte, contentType string) (*models.Message, error)
+	// Summarize folds history into summary.
+	Summarize(ctx context.Context, summary string, history []models.History) (string, error)
+}
+
+type LarnConfig struct {
+	BaseURL string
+	// Timeout bounds a single attempt; retries get a fresh one each.
+	Timeout time.Duration
+	// MaxRetries is how many times a 5xx or network failure is retried,
+	// waiting RetryBackoff before the first retry and doubling after that.
+	MaxRetries   int
+	RetryBackoff time.Duration
+	// StreamTimeout bounds a whole streamed answer.
+	StreamTimeout time.Duration
+}
+
+// APIError is returned when the Larn API answers with a non-2xx status.
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("larn api returned %d: %s", e.StatusCode, e.Message)
+}
+
+type httpLarnClient struct {
+	config LarnConfig
+	client *http.Client
+	stream *http.Client
+}
+
+func NewHTTPLarnClient(config LarnConfig) LarnClient {
+	return &httpLarnClient{
+		config: config,
+		client: &http.Client{
+			Timeout: config.Timeout,
+		},
+		stream: &http.Client{},
+	}
+}
+
+func (l *httpLarnClient) Message(ctx context.Context, message string, summary string, history []models.History) (*models.Message, error) {
 	payload := map[string]any{
 		"message": message,
 		"history": history,
 	}
+	if summary != "" {
+		payload["summary"] = summary
+	}
 
-	marshalled, err := json.Marshal(payload)
+	var response models.Message
+
+	if err := l.post(ctx, "/ai/message", payload, &response); err != nil {
+		return nil, upstreamError("get larn message", err)
+	}
 
+	return &response, nil
+}
+
+func (l *httpLarnClient) post(ctx context.Context, path string, payload any, out any) error {
+	marshalled, err := json.Marshal(payload)
 	if err != nil {
-		log.Fatal("Cannot marshal json message")
+		return err
 	}
 
-	url := os.Getenv("LARN_API_URL") + "/ai/message"
+	backoff := l.config.RetryBackoff
 
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(marshalled))
+	for attempt := 0; ; attempt++ {
+		retry, err := l.do(ctx, path, marshalled, out)
+		if err == nil || !retry || attempt >= l.config.MaxRetries {
+			return err
+		}
 
-	req.Header.Set("Content-Type", "application/json")
+		select {
+		case <-ctx.Done():
+			return errors.Join(err, ctx.Err())
+		case <-time.After(backoff):
+		}
 
-	if err != nil {
-		log.Fatal("Request Failed")
+		backoff *= 2
 	}
+}
 
-	client := &http.Client{}
+// do makes a single attempt and reports whether a failure is worth retrying.
+func (l *httpLarnClient) do(ctx context.Context, path string, body []byte, out any) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", l.config.BaseURL+path, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
 
-	res, err := client.Do(req)
+	req.Header.Set("Content-Type", "application/json")
 
+	res, err := l.client.Do(req)
 	if err != nil {
-		log.Fatal(err)
+		return ctx.Err() == nil, err
 	}
 
 	defer res.Body.Close()
 
-	body, err := io.ReadAll(res.Body)
-
+	resBody, err := io.ReadAll(res.Body)
 	if err != nil {
-		log.Fatal(err)
+		return true, err
 	}
 
-	var response models.Message
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return res.StatusCode >= 500, &APIError{
+			StatusCode: res.StatusCode,
+			Message:    errorMessage(resBody),
+		}
+	}
 
-	err = json.Unmarshal(body, &response)
+	return false, json.Unmarshal(resBody, out)
+}
 
-	if err != nil {
-		return nil, err
+// errorMessage pulls a readable message out of an error body, which is JSON
+// from the API itself but may be plain text from a proxy in front of it.
+func errorMessage(body []byte) string {
+	var decoded struct {
+		Error   string `json:"error"`
+		Message string `json:"message"`
+		Detail  string `json:"detail"`
 	}
 
-	return &response, nil
+	if err := json.Unmarshal(body, &decoded); err == nil {
+		for _, message := range []string{decoded.Message, decoded.Error, decoded.Detail} {
+			if message != "" {
+				return message
+			}
+		}
+	}
+
+	text := []rune(strings.TrimSpace(string(body)))
+	if len(text) > 200 {
+		text = text[:200]
+	}
 
+	return string(text)
 }
@@ -2,55 +2,223 @@ package services
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
-	"fmt"
 	"larn-line/internal/constants"
+	"larn-line/internal/markup"
 	"larn-line/internal/models"
+	"larn-line/internal/objectstore"
+	"larn-line/internal/render"
+	"larn-line/internal/rules"
+	"larn-line/internal/scamcheck"
+	"larn-line/internal/speech"
+	"larn-line/internal/store"
 	"larn-line/internal/utils"
 	"log"
-	"strings"
+	"reflect"
+	"sync/atomic"
+	"time"
 
-	"cloud.google.com/go/firestore"
 	"github.com/gin-gonic/gin"
 	"github.com/line/line-bot-sdk-go/v8/linebot/messaging_api"
 	"github.com/line/line-bot-sdk-go/v8/linebot/webhook"
-	"google.golang.org/api/iterator"
-	"google.golang.org/grpc/codes"
-	"google.golang.org/grpc/status"
 )
 
 type LineService struct {
-	bot           *messaging_api.MessagingApiAPI
-	channelSecret string
-	channelToken  string
-	firestore     *firestore.Client
-	quickReplies  *messaging_api.QuickReply
+	bot                 *messaging_api.MessagingApiAPI
+	blob                *messaging_api.MessagingApiBlobAPI
+	channelSecret       string
+	channelToken        string
+	store               store.Store
+	quickReplies        *messaging_api.QuickReply
+	larn                LarnClient
+	speechToText        speech.SpeechToText
+	textToSpeech        speech.TextToSpeech
+	objects             objectstore.ObjectStore
+	readAloudOnly       bool
+	flexReplies         bool
+	readMoreTTL         time.Duration
+	historyTurns        int
+	historyTokens       int
+	agentSwitch         AgentSwitchPolicy
+	keepSessions        int
+	recommends          *recommendCache
+	recommendTimeout    time.Duration
+	suggestions         map[string][]string
+	streamAnswers       bool
+	streamPushes        int
+	pushReserve         int64
+	pushQuota           *pushQuota
+	postbacks           *PostbackRouter
+	commands            *CommandRouter
+	roster              *Roster
+	scamClassifications []string
+	rules               *rules.Engine
+	scamChecker         *scamcheck.Checker
+	operators           *operatorHub
+	turns               *userLocks
+	compactions         *userLocks
+	groupPrefixes       []string
+	self                botUser
+	queue               *EventQueue
+	idempotency         IdempotencyStore
+	duplicates          atomic.Int64
 }
 
-func NewLineService(channelSecret string, channelToken string) (*LineService, error) {
+type Config struct {
+	ChannelSecret string
+	ChannelToken  string
+
+	Larn        LarnClient
+	Store       store.Store
+	Idempotency IdempotencyStore
+	// SpeechToText transcribes voice messages. Without it they are ignored.
+	SpeechToText speech.SpeechToText
+	// TextToSpeech and Objects voice answers for users who turned on read
+	// aloud. With ReadAloudOnly the voice replaces the text bubbles.
+	TextToSpeech  speech.TextToSpeech
+	Objects       objectstore.ObjectStore
+	ReadAloudOnly bool
+
+	// FlexReplies sends step-by-step answers as a carousel of bubbles.
+	FlexReplies bool
+	// ReadMoreTTL is how long the rest of a long answer can be read.
+	ReadMoreTTL time.Duration
+
+	// HistoryTurns and HistoryTokens bound the history sent to Larn with
+	// each message. Older turns are summarized.
+	HistoryTurns  int
+	HistoryTokens int
+	// AgentSwitch is whether a question for another agent starts a new
+	// session.
+	AgentSwitch AgentSwitchPolicy
+	// KeepSessions is how many sessions a user keeps. Older ones are deleted
+	// with their turns when another starts; zero keeps them all.
+	KeepSessions int
+
+	// RecommendTimeout is how long an answer waits for Larn's follow-up
+	// questions before offering Suggestions for its classification, or the
+	// default quick replies. RecommendCacheSize answers keep theirs.
+	RecommendTimeout   time.Duration
+	RecommendCacheSize int
+	Suggestions        map[string][]string
+
+	// StreamAnswers replies with the first part of an answer while Larn is
+	// still writing it and pushes the rest, in at most StreamPushes push
+	// messages. Answers are not streamed when fewer than PushReserve push
+	// messages would be left this month.
+	StreamAnswers bool
+	StreamPushes  int
+	PushReserve   int64
+
+	// Roster is who the user is handed over to when asking for a real
+	// grandchild.
+	Roster *Roster
+
+	// ScamClassifications are the Larn classifications of questions about
+	// scams, which caregivers are told about.
+	ScamClassifications []string
+	// Rules decide what happens after a message is answered, such as
+	// alerting caregivers.
+	Rules []rules.Rule
+	// ScamChecker answers what it can about links and phone numbers in a
+	// question before Larn does.
+	ScamChecker *scamcheck.Checker
+
+	// GroupPrefixes start the messages a group or room addresses to the
+	// bot, besides mentioning it.
+	GroupPrefixes []string
+
+	// Workers is the number of goroutines draining the event queue and
+	// QueueSize the total number of events waiting across all of them.
+	Workers   int
+	QueueSize int
+}
+
+func NewLineService(config Config) (*LineService, error) {
 
 	bot, err := messaging_api.NewMessagingApiAPI(
-		channelToken,
+		config.ChannelToken,
 	)
 
 	if err != nil {
 		return nil, err
 	}
 
-	firestore, err := NewFirestore()
+	blob, err := messaging_api.NewMessagingApiBlobAPI(
+		config.ChannelToken,
+	)
+
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 
 	quickReply := utils.CreateQuickReply([]string{"เพิ่มขนาดตัวอักษร", "ตั้งค่าการแจ้งเตือนให้มีเสียงดังขึ้น", "วิธีถ่ายภาพหน้าจอ", "จะส่งรูปภาพทางไลน์", "วิธีตั้งนาฬิกาปลุก", "เชื่อม WiFi กับโทรศัพท์", "ลบแอปพลิเคชัน", "เปิดใช้งานโหมดประหยัดแบตเตอรี่"})
 
-	return &LineService{
-		bot,
-		channelSecret,
-		channelToken,
-		firestore,
-		quickReply,
-	}, nil
+	app := &LineService{
+		bot:                 bot,
+		blob:                blob,
+		channelSecret:       config.ChannelSecret,
+		channelToken:        config.ChannelToken,
+		store:               config.Store,
+		quickReplies:        quickReply,
+		larn:                config.Larn,
+		idempotency:         config.Idempotency,
+		speechToText:        config.SpeechToText,
+		textToSpeech:        config.TextToSpeech,
+		objects:             config.Objects,
+		readAloudOnly:       config.ReadAloudOnly,
+		flexReplies:         config.FlexReplies,
+		readMoreTTL:         config.ReadMoreTTL,
+		historyTurns:        config.HistoryTurns,
+		historyTokens:       config.HistoryTokens,
+		agentSwitch:         config.AgentSwitch,
+		keepSessions:        config.KeepSessions,
+		recommends:          newRecommendCache(config.RecommendCacheSize),
+		recommendTimeout:    config.RecommendTimeout,
+		suggestions:         config.Suggestions,
+		streamAnswers:       config.StreamAnswers && config.StreamPushes > 0,
+		streamPushes:        config.StreamPushes,
+		pushReserve:         config.PushReserve,
+		pushQuota:           &pushQuota{},
+		roster:              config.Roster,
+		scamClassifications: config.ScamClassifications,
+		scamChecker:         config.ScamChecker,
+		postbacks:           NewPostbackRouter(),
+		operators:           newOperatorHub(),
+		turns:               newUserLocks(),
+		compactions:         newUserLocks(),
+		groupPrefixes:       config.GroupPrefixes,
+	}
+
+	app.commands = NewCommandRouter(func(cmd Command) error {
+		return app.handleLarnMessage(cmd.UserId, cmd.Text, cmd.ReplyToken)
+	})
+
+	app.registerPostbacks()
+	app.registerCommands()
+
+	app.rules, err = rules.NewEngine(config.Rules, config.Store)
+	if err != nil {
+		return nil, err
+	}
+	app.rules.Register(AlertCaregiversAction, app.alertCaregivers)
+
+	app.queue = NewEventQueue(config.Workers, config.QueueSize, app.handleEvent)
+
+	return app, nil
+}
+
+// Close waits for the events already acknowledged to LINE to be handled.
+func (app *LineService) Close() {
+	app.queue.Close()
+}
+
+func (app *LineService) Stats(c *gin.Context) {
+	c.JSON(200, gin.H{
+		"queue":      app.queue.Stats(),
+		"duplicates": app.duplicates.Load(),
+	})
 }
 
 func (app *LineService) Callback(c *gin.Context) {
@@ -67,98 +235,208 @@ func (app *LineService) Callback(c *gin.Context) {
 		return
 	}
 
-	log.Println("Handling events...")
-
+	// LINE redelivers the request when it fails, so an event that could not
+	// be queued is not lost. The ones that were are skipped the second time
+	// as duplicates.
+	dropped := false
 	for _, event := range cb.Events {
-		switch e := event.(type) {
-		case webhook.MessageEvent:
-			switch s := e.Source.(type) {
-			case webhook.UserSource:
-				app.bot.ShowLoadingAnimation(&messaging_api.ShowLoadingAnimationRequest{
-					ChatId:         s.UserId,
-					LoadingSeconds: 60,
-				})
-				switch message := e.Message.(type) {
-				case webhook.TextMessageContent:
-					switch message.Text {
-					case constants.NEWS_CHECK:
-						app.sendNewsTut(e.ReplyToken)
-					case constants.READ_MORE:
-						app.sendTmpMessages(s.UserId, e.ReplyToken)
-					default:
-						app.handleLarnMessage(s.UserId, message.Text, e.ReplyToken)
-					}
-				default:
-					log.Printf("Unsupported message content: %T\n", e.Message)
+		if !app.queue.Enqueue(sourceKey(metaOf(event).source), event) {
+			log.Printf("Event queue full, dropped %s event\n", event.GetType())
+			dropped = true
+		}
+	}
+
+	if dropped {
+		c.Status(503)
+		return
+	}
+	c.Status(200)
+}
+
+func (app *LineService) handleEvent(event webhook.EventInterface) {
+	meta := metaOf(event)
+	if app.alreadyHandled(meta) {
+		return
+	}
+
+	// An event that failed for a reason that may pass is forgotten, so that
+	// LINE redelivering it is not skipped as a duplicate.
+	handleError := func(replyToken string, err error) {
+		app.forgetFailed(meta, err)
+		app.handleError(replyToken, err)
+	}
+	handleGroupError := func(chatId string, replyToken string, err error) {
+		app.forgetFailed(meta, err)
+		app.handleGroupError(chatId, replyToken, err)
+	}
+
+	switch e := event.(type) {
+	case webhook.MessageEvent:
+		switch s := e.Source.(type) {
+		case webhook.UserSource:
+			if app.isHumanMode(s.UserId) {
+				if err := app.handleHumanMessage(s.UserId, e.Message, e.ReplyToken); err != nil {
+					handleError(e.ReplyToken, err)
+				}
+				break
+			}
+
+			app.bot.ShowLoadingAnimation(&messaging_api.ShowLoadingAnimationRequest{
+				ChatId:         s.UserId,
+				LoadingSeconds: 60,
+			})
+			switch message := e.Message.(type) {
+			case webhook.TextMessageContent:
+				if err := app.commands.Dispatch(Command{
+					UserId:     s.UserId,
+					ReplyToken: e.ReplyToken,
+					Text:       message.Text,
+				}); err != nil {
+					handleError(e.ReplyToken, err)
+				}
+			case webhook.ImageMessageContent:
+				if err := app.handleImageMessage(s.UserId, message, e.ReplyToken); err != nil {
+					handleError(e.ReplyToken, err)
+				}
+			case webhook.AudioMessageContent:
+				if err := app.handleAudioMessage(s.UserId, message, e.ReplyToken); err != nil {
+					handleError(e.ReplyToken, err)
 				}
 			default:
 				log.Printf("Unsupported message content: %T\n", e.Message)
 			}
-		case webhook.FollowEvent:
-			switch s := e.Source.(type) {
-			case webhook.UserSource:
-				app.bot.ShowLoadingAnimation(&messaging_api.ShowLoadingAnimationRequest{
-					ChatId:         s.UserId,
-					LoadingSeconds: 60,
-				})
-
-				app.createUserIfNotExist(s.UserId)
+		case webhook.GroupSource, webhook.RoomSource:
+			if err := app.handleGroupMessage(sourceKey(s), e); err != nil {
+				handleGroupError(sourceKey(s), e.ReplyToken, err)
+			}
+		default:
+			log.Printf("Unsupported message source: %T\n", e.Source)
+		}
+	case webhook.FollowEvent:
+		switch s := e.Source.(type) {
+		case webhook.UserSource:
+			app.bot.ShowLoadingAnimation(&messaging_api.ShowLoadingAnimationRequest{
+				ChatId:         s.UserId,
+				LoadingSeconds: 60,
+			})
+
+			if err := app.createUserIfNotExist(s.UserId); err != nil {
+				log.Printf("Cannot create user %s: %+v\n", s.UserId, err)
 			}
+		}
 
-			if _, err := app.bot.ReplyMessage(
-				&messaging_api.ReplyMessageRequest{
-					ReplyToken: e.ReplyToken,
-					Messages: []messaging_api.MessageInterface{
-						&messaging_api.TextMessage{
-							Text:       constants.WELCOME_MESSAGE,
-							QuickReply: app.quickReplies,
-						},
-
-						&messaging_api.TextMessage{
-							Text:       constants.EXAMPLE_MESSAGE_1,
-							QuickReply: app.quickReplies,
-						},
-						&messaging_api.TextMessage{
-							Text:       constants.EXAMPLE_MESSAGE_2,
-							QuickReply: app.quickReplies,
-						},
-						&messaging_api.TextMessage{
-							Text:       constants.EXAMPLE_MESSAGE_3,
-							QuickReply: app.quickReplies,
-						},
+		if _, err := app.bot.ReplyMessage(
+			&messaging_api.ReplyMessageRequest{
+				ReplyToken: e.ReplyToken,
+				Messages: []messaging_api.MessageInterface{
+					&messaging_api.TextMessage{
+						Text:       constants.WELCOME_MESSAGE,
+						QuickReply: app.quickReplies,
+					},
+
+					&messaging_api.TextMessage{
+						Text:       constants.EXAMPLE_MESSAGE_1,
+						QuickReply: app.quickReplies,
+					},
+					&messaging_api.TextMessage{
+						Text:       constants.EXAMPLE_MESSAGE_2,
+						QuickReply: app.quickReplies,
+					},
+					&messaging_api.TextMessage{
+						Text:       constants.EXAMPLE_MESSAGE_3,
+						QuickReply: app.quickReplies,
 					},
 				},
-			); err != nil {
-				log.Fatal(err)
-			}
+			},
+		); err != nil {
+			log.Print(err)
+		}
 
-		case webhook.UnfollowEvent:
-			ctx := context.Background()
+	case webhook.UnfollowEvent:
+		ctx := context.Background()
 
-			switch s := e.Source.(type) {
-			case webhook.UserSource:
-				app.firestore.Collection("users").Doc(s.UserId).Delete(ctx)
-				utils.DeleteCollection(app.firestore, fmt.Sprintf("users/%s/messages", s.UserId))
-				utils.DeleteCollection(app.firestore, fmt.Sprintf("users/%s/tmp_messages", s.UserId))
+		switch s := e.Source.(type) {
+		case webhook.UserSource:
+			if err := app.removeLinks(ctx, s.UserId); err != nil {
+				log.Printf("Cannot unlink %s: %+v\n", s.UserId, err)
+			}
+			if err := app.store.DeleteUser(ctx, s.UserId); err != nil {
+				log.Printf("Cannot delete user %s: %+v\n", s.UserId, err)
 			}
+		}
 
-		default:
-			log.Printf("Unsupported message: %T\n", event)
+	case webhook.PostbackEvent:
+		switch s := e.Source.(type) {
+		case webhook.UserSource:
+			if err := app.postbacks.Dispatch(parsePostback(s.UserId, e)); err != nil {
+				handleError(e.ReplyToken, err)
+			}
+		case webhook.GroupSource, webhook.RoomSource:
+			if err := app.postbacks.Dispatch(parsePostback(sourceKey(s), e)); err != nil {
+				handleGroupError(sourceKey(s), e.ReplyToken, err)
+			}
 		}
+
+	case webhook.JoinEvent:
+		app.joinGroup(sourceKey(e.Source), e.ReplyToken)
+
+	case webhook.LeaveEvent:
+		app.leaveGroup(sourceKey(e.Source))
+
+	case webhook.MemberJoinedEvent:
+		app.replyChatText(sourceKey(e.Source), e.ReplyToken, constants.GROUP_MEMBER_JOINED_MESSAGE)
+
+	case webhook.MemberLeftEvent:
+
+	default:
+		log.Printf("Unsupported message: %T\n", event)
+	}
+}
+
+func (app *LineService) alreadyHandled(meta eventMeta) bool {
+	if meta.webhookEventId == "" {
+		return false
+	}
+
+	seen, err := app.idempotency.MarkSeen(context.Background(), meta.webhookEventId)
+	if err != nil {
+		log.Printf("Cannot check webhook event %s: %+v\n", meta.webhookEventId, err)
+		return false
 	}
 
+	if seen {
+		app.duplicates.Add(1)
+		log.Printf("Skipping already handled event %s (redelivery: %t)\n", meta.webhookEventId, meta.redelivery)
+	}
+
+	return seen
+}
+
+// forgetFailed forgets an event that failed on storage or Larn.
+func (app *LineService) forgetFailed(meta eventMeta, err error) {
+	if meta.webhookEventId == "" {
+		return
+	}
+	if kind := ErrorKindOf(err); kind != KindStorage && kind != KindUpstream {
+		return
+	}
+
+	if err := app.idempotency.Forget(context.Background(), meta.webhookEventId); err != nil {
+		log.Printf("Cannot forget webhook event %s: %+v\n", meta.webhookEventId, err)
+	}
 }
 
-func (app *LineService) sendNewsTut(replyToken string) {
+func (app *LineService) sendNewsTut(chatId string, replyToken string) {
+	quickReply := app.quickRepliesFor(chatId)
 	messages := []messaging_api.MessageInterface{
 		&messaging_api.TextMessage{
 			Text:       constants.NEWS_CHECK_MESSAGE,
-			QuickReply: app.quickReplies,
+			QuickReply: quickReply,
 		},
 		&messaging_api.VideoMessage{
 			OriginalContentUrl: "https://storage.googleapis.com/smooth-brain-bucket/ShareChat.mov",
 			PreviewImageUrl:    "https://storage.googleapis.com/smooth-brain-bucket/Untitled%20design.png",
-			QuickReply:         app.quickReplies,
+			QuickReply:         quickReply,
 		},
 	}
 
@@ -174,151 +452,66 @@ func (app *LineService) sendNewsTut(replyToken string) {
 	}
 }
 
-func (app *LineService) createUserIfNotExist(userId string) {
-	ctx := context.Background()
-	userDoc := app.firestore.Collection("users").Doc(userId)
-
-	_, err := userDoc.Get(ctx)
-	if err != nil {
-		if status.Code(err) == codes.NotFound {
-			updateUserAgent(userDoc, ctx, &map[string]any{
-				"currentAgent": nil,
-			})
-		}
+func (app *LineService) createUserIfNotExist(userId string) error {
+	if err := app.store.CreateUserIfNotExist(context.Background(), userId); err != nil {
+		return storageError("create user", err)
 	}
-
+	return nil
 }
 
-func (app *LineService) handleLarnMessage(userId string, text string, replyToken string) error {
+// handleLarnMessage answers text with Larn. Any leading messages are sent
+// ahead of the answer in the same reply.
+func (app *LineService) handleLarnMessage(userId string, text string, replyToken string, leading ...messaging_api.MessageInterface) error {
 
 	ctx := context.Background()
 
-	userDoc := app.firestore.Collection("users").Doc(userId)
-
-	histories := getUserHistory(userDoc, ctx)
-
-	res, err := GetLarn(text, histories)
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	if err != nil {
-		log.Fatal(err)
+	if verdict := app.scamVerdict(text); verdict != nil {
+		leading = append(leading, verdict)
 	}
 
-	c := make(chan *models.Message)
-
-	go func() {
-
-		message := <-c
-
-		user, err := userDoc.Get(ctx)
-		if err != nil {
-			log.Fatal(err)
-		}
-
-		if u := user.Data(); u["currentAgent"] != message.Classification {
-			updateUserAgent(userDoc, ctx,
-				&map[string]interface{}{
-					"currentAgent": message.Classification,
-				},
-			)
-			utils.DeleteCollection(app.firestore, fmt.Sprintf("users/%s/messages", userId))
+	// Held until the turn is saved, so the next message sees it. Until
+	// finishTurn takes it over it is released on return, panics included,
+	// so that the user's next event does not wait forever.
+	release := app.turns.lock(userId)
+	handedOff := false
+	defer func() {
+		if !handedOff {
+			release()
 		}
-
-		sendMessage(userDoc, ctx, text, "user")
-		sendMessage(userDoc, ctx, message.Response, "model")
-
 	}()
+	finish := func(res *models.Message) {
+		handedOff = true
+		go app.finishTurn(ctx, userId, "text", text, res, release)
+	}
 
-	c <- res
-
-	splitMessages := strings.Split(res.Response, "% % % % %")
-
-	allMessages := make([]messaging_api.MessageInterface, 0)
-
-	recommends, err := GetRecommend(res.Response)
-
-	quickReply := utils.CreateQuickReply(recommends)
-
-	currentMessage := 0
-	for _, message := range splitMessages {
-
-		imgIdx := utils.IndexOf(message, '[')
-
-		if imgIdx == -1 {
-			text := strings.TrimSpace(message)
-			if len(text) != 0 {
-				allMessages = append(allMessages,
-					messaging_api.TextMessage{
-						Text:       text,
-						QuickReply: quickReply,
-					},
-				)
-				currentMessage++
-			}
-		} else {
-
-			imagePart := message[imgIdx:]
-
-			endOfImg := imgIdx + utils.IndexOf(imagePart, ']')
-
-			image := message[imgIdx+1 : endOfImg]
-
-			text := strings.TrimSpace(message[:imgIdx])
-			if len(text) > 0 {
-
-				allMessages = append(allMessages,
-					messaging_api.TextMessage{
-						Text:       text,
-						QuickReply: quickReply,
-					},
-				)
-				currentMessage++
-			}
+	summary, histories, err := app.loadHistory(ctx, userId)
+	if err != nil {
+		return err
+	}
 
-			if strings.HasPrefix(image, "https://") {
-				allMessages = append(allMessages,
-					messaging_api.ImageMessage{
-						OriginalContentUrl: image,
-						PreviewImageUrl:    image,
-						QuickReply:         quickReply,
-					},
-				)
-				currentMessage++
-			}
-		}
+	if app.streamAnswers && app.canPush(app.streamPushes) {
+		return app.streamLarnMessage(ctx, userId, text, replyToken, summary, histories, finish, leading...)
+	}
 
+	res, err := app.larn.Message(ctx, text, summary, histories)
+	if err != nil {
+		return err
 	}
 
-	messagesLength := len(allMessages)
-	var finalMessages []messaging_api.MessageInterface
+	finish(res)
 
-	if messagesLength <= 5 {
-		finalMessages = allMessages
-	} else {
-		tmpMessages := allMessages[5:]
-		saveTmpMessage(userDoc, ctx, tmpMessages)
-		quickReply = utils.CreateQuickReply([]string{"อ่านต่อ"})
-
-		for i, message := range allMessages[:5] {
-			switch m := message.(type) {
-			case messaging_api.TextMessage:
-				if i == 4 {
-					m.QuickReply = quickReply
-				}
-				finalMessages = append(finalMessages, m)
-			case messaging_api.ImageMessage:
-				if i == 4 {
-					m.QuickReply = quickReply
-				}
-				finalMessages = append(finalMessages, m)
-			}
+	return app.replyLarnResponse(ctx, userId, replyToken, res, leading...)
+}
 
-		}
+// replyLarnResponse renders a Larn answer into LINE messages, keeping
+// anything past the first five for "read more".
+func (app *LineService) replyLarnResponse(ctx context.Context, userId string, replyToken string, res *models.Message, leading ...messaging_api.MessageInterface) error {
+	finalMessages, err := app.larnMessages(ctx, userId, res, res.Response, leading...)
+	if err != nil {
+		return err
 	}
 
-	if _, err = app.bot.ReplyMessage(
+	if _, err := app.bot.ReplyMessage(
 		&messaging_api.ReplyMessageRequest{
 			ReplyToken: replyToken,
 			Messages:   finalMessages,
@@ -331,160 +524,222 @@ func (app *LineService) handleLarnMessage(userId string, text string, replyToken
 	return nil
 }
 
-func getUserHistory(userDoc *firestore.DocumentRef, ctx context.Context) []models.History {
+// larnMessages renders text, the whole of res or the part of it not sent
+// yet, with the recommendations for res as quick replies. Anything past the
+// first page is kept for "read more".
+func (app *LineService) larnMessages(ctx context.Context, userId string, res *models.Message, text string, leading ...messaging_api.MessageInterface) ([]messaging_api.MessageInterface, error) {
+	waitRecommends := app.recommend(res)
 
-	iter := userDoc.Collection("messages").Documents(ctx)
+	// Every message shares quickReply, which is filled in once the
+	// recommendations are in, so rendering and reading aloud need not wait.
+	quickReply := &messaging_api.QuickReply{}
 
-	histories := make([]models.History, 0)
+	allMessages := append([]messaging_api.MessageInterface{}, leading...)
+	allMessages = append(allMessages, app.render(text, quickReply)...)
 
-	for {
-		doc, err := iter.Next()
-		if err == iterator.Done {
-			break
-		}
-		if err != nil {
-			log.Fatal(err)
-		}
+	if len(allMessages) == len(leading) {
+		return nil, renderingError("render larn response", errors.New("no messages to send"))
+	}
 
-		var history models.History
-		if err := doc.DataTo(&history); err != nil {
-			log.Fatal(err)
-		}
+	allMessages = app.readAloud(ctx, userId, allMessages)
 
-		histories = append(histories, history)
-	}
+	quickReply.Items = app.addressed(userId, waitRecommends()).Items
 
-	return histories
+	return app.paginate(ctx, userId, allMessages)
 }
 
-func updateUserAgent(userDoc *firestore.DocumentRef, ctx context.Context, body *map[string]any) {
-	_, err := userDoc.Set(ctx, body)
-	if err != nil {
-		log.Fatal(err)
+// render turns Larn markup into messages, as a carousel when flex replies
+// are on and the answer suits one.
+func (app *LineService) render(text string, quickReply *messaging_api.QuickReply) []messaging_api.MessageInterface {
+	doc := markup.Parse(text)
+	for _, d := range doc.Diagnostics {
+		log.Printf("Larn response markup at %s\n", d)
 	}
+
+	if app.flexReplies {
+		if rendered, ok := render.Flex(doc, quickReply); ok {
+			return rendered
+		}
+	}
+	return render.Messages(doc, quickReply)
 }
 
-func sendMessage(userDoc *firestore.DocumentRef, ctx context.Context, message string, from string) {
-	_, _, err := userDoc.Collection("messages").Add(ctx, map[string]any{
-		"from":      from,
-		"message":   message,
-		"timestamp": firestore.ServerTimestamp,
-	})
+// finishTurn saves a turn, releases the user's lock and then runs what
+// follows from the answer. kind is "text" or "image", for an image whose
+// text only stands in for it in the history.
+func (app *LineService) finishTurn(ctx context.Context, userId string, kind string, text string, message *models.Message, release func()) {
+	sessionId, started, err := app.saveTurn(ctx, userId, text, message)
+	release()
 	if err != nil {
-		log.Fatal(err)
+		log.Printf("Cannot save conversation of %s: %+v\n", userId, err)
+		return
 	}
-}
 
-func saveTmpMessage(userDoc *firestore.DocumentRef, ctx context.Context, messages []messaging_api.MessageInterface) {
-	var payload map[string]any
-	for _, message := range messages {
-		switch m := message.(type) {
-		case messaging_api.TextMessage:
-			payload = map[string]any{
-				"type":      "message",
-				"text":      m.Text,
-				"timestamp": firestore.ServerTimestamp,
-			}
-		case messaging_api.ImageMessage:
-			payload = map[string]any{
-				"type":      "image",
-				"original":  m.OriginalContentUrl,
-				"preview":   m.PreviewImageUrl,
-				"timestamp": firestore.ServerTimestamp,
-			}
+	if started && app.keepSessions > 0 {
+		if err := app.store.PruneSessions(ctx, userId, app.keepSessions); err != nil {
+			log.Printf("Cannot delete old sessions of %s: %+v\n", userId, err)
 		}
+	}
 
-		_, _, err := userDoc.Collection("tmp_messages").Add(ctx, payload)
-		if err != nil {
-			log.Fatal(err)
+	// Summarizing waits on Larn, so it runs after the lock is released to
+	// keep the user's next message, and its shard, from waiting with it.
+	// One runs at a time per user; a turn finishing meanwhile leaves its
+	// part to the next one.
+	if release, ok := app.compactions.tryLock(userId); ok {
+		if err := app.compactHistory(ctx, userId, sessionId); err != nil {
+			log.Printf("Cannot summarize history of %s: %+v\n", userId, err)
 		}
+		release()
 	}
-}
 
-func getTmpMessages(userDoc *firestore.DocumentRef, ctx context.Context) []models.TmpHistory {
+	event := rules.Event{
+		UserId:         userId,
+		Kind:           kind,
+		Text:           text,
+		Classification: message.Classification,
+		Response:       message.Response,
+	}
 
-	iter := userDoc.Collection("tmp_messages").OrderBy("timestamp", firestore.Asc).Limit(5).Documents(ctx)
+	user, err := app.store.GetUser(ctx, userId)
+	if err != nil {
+		log.Printf("Cannot get user %s: %+v\n", userId, err)
+	} else if len(user.Caregivers) > 0 && user.SharingConsentAt != nil {
+		app.recordActivity(ctx, event)
+	}
 
-	histories := make([]models.TmpHistory, 0)
+	app.rules.Evaluate(ctx, event)
+}
 
-	for {
-		doc, err := iter.Next()
-		if err == iterator.Done {
-			break
+// saveTurn stores the user's question and the model's answer together with
+// any move to a new session. It returns the session the turn went to and
+// whether the turn started it.
+func (app *LineService) saveTurn(ctx context.Context, userId string, text string, message *models.Message) (string, bool, error) {
+	var sessionId string
+	var started bool
+
+	if err := app.store.SaveTurn(ctx, userId, func(user *models.User) (*models.Turn, error) {
+		turn := &models.Turn{
+			Histories: []models.History{
+				{From: "user", Message: text},
+				{From: "model", Message: message.Response},
+			},
 		}
-		if err != nil {
-			log.Fatal(err)
-		}
-
-		var history models.TmpHistory
+		turn.Session, turn.Legacy = app.moveSession(user, text, message.Classification)
+		sessionId, started = user.SessionId, turn.Session != nil
+		return turn, nil
+	}); err != nil {
+		return "", false, storageError("save turn", err)
+	}
 
-		m := doc.Data()
+	return sessionId, started, nil
+}
 
-		switch m["type"].(string) {
-		case "message":
-			history = models.TmpHistory{
-				Message: models.TextMessage{
-					Text: m["text"].(string),
-				},
-			}
-		case "image":
-			history = models.TmpHistory{
-				Message: models.ImageMessage{
-					Preview:  m["preview"].(string),
-					Original: m["original"].(string),
+// replyText replies with a single text message and the default quick
+// replies.
+func (app *LineService) replyText(replyToken string, text string) {
+	if _, err := app.bot.ReplyMessage(
+		&messaging_api.ReplyMessageRequest{
+			ReplyToken: replyToken,
+			Messages: []messaging_api.MessageInterface{
+				&messaging_api.TextMessage{
+					Text:       text,
+					QuickReply: app.quickReplies,
 				},
-			}
-		}
-
-		_, err = userDoc.Collection("tmp_messages").Doc(doc.Ref.ID).Delete(ctx)
-		if err != nil {
-			log.Println(err)
-		}
-
-		histories = append(histories, history)
+			},
+		},
+	); err != nil {
+		log.Print(err)
 	}
-
-	return histories
 }
 
-func (app *LineService) sendTmpMessages(userId string, replyToken string) {
-	userDoc := app.firestore.Collection("users").Doc(userId)
-	ctx := context.Background()
-
-	histories := getTmpMessages(userDoc, ctx)
-
-	var allMessages []messaging_api.MessageInterface
-	for _, history := range histories {
-		switch h := history.Message.(type) {
-		case models.TextMessage:
-			allMessages = append(allMessages, &messaging_api.TextMessage{
-				Text: h.GetText(),
-			})
-		case models.ImageMessage:
-			allMessages = append(allMessages, &messaging_api.ImageMessage{
-				PreviewImageUrl:    h.GetPreview(),
-				OriginalContentUrl: h.GetOriginal(),
-			})
-		}
-
+// replyChatText is replyText for a chat that may be a group, where the
+// quick replies have to address the bot.
+func (app *LineService) replyChatText(chatId string, replyToken string, text string) {
+	if _, err := app.bot.ReplyMessage(
+		&messaging_api.ReplyMessageRequest{
+			ReplyToken: replyToken,
+			Messages: []messaging_api.MessageInterface{
+				&messaging_api.TextMessage{
+					Text:       text,
+					QuickReply: app.quickRepliesFor(chatId),
+				},
+			},
+		},
+	); err != nil {
+		log.Print(err)
 	}
+}
 
-	if len(allMessages) == 0 {
-		allMessages = append(allMessages, &messaging_api.TextMessage{
-			Text:       "ไม่มีข้อความให้อ่านต่อแล้วค่ะ 🤗",
-			QuickReply: app.quickReplies,
-		})
-		return
-	}
+// handleError replies to the user with an apology instead of leaving them
+// waiting on the loading animation.
+func (app *LineService) handleError(replyToken string, err error) {
+	log.Printf("Cannot handle message: %+v\n", err)
 
 	if _, err := app.bot.ReplyMessage(
 		&messaging_api.ReplyMessageRequest{
 			ReplyToken: replyToken,
-			Messages:   allMessages,
+			Messages: []messaging_api.MessageInterface{
+				&messaging_api.TextMessage{
+					Text:       errorText(err),
+					QuickReply: app.quickReplies,
+				},
+			},
 		},
 	); err != nil {
 		log.Print(err)
-	} else {
-		log.Println("Sent text reply.")
 	}
 }
+
+func errorText(err error) string {
+	if ErrorKindOf(err) == KindUpstream {
+		return constants.UPSTREAM_ERROR_MESSAGE
+	}
+	return constants.ERROR_MESSAGE
+}
+
+func toTmpHistories(messages []messaging_api.MessageInterface) ([]models.TmpHistory, error) {
+	histories := make([]models.TmpHistory, 0, len(messages))
+	for _, message := range messages {
+		switch m := message.(type) {
+		case messaging_api.TextMessage:
+			histories = append(histories, models.TmpHistory{
+				Message: models.TextMessage{
+					Text: m.Text,
+				},
+			})
+		case messaging_api.ImageMessage:
+			histories = append(histories, models.TmpHistory{
+				Message: models.ImageMessage{
+					Preview:  m.PreviewImageUrl,
+					Original: m.OriginalContentUrl,
+				},
+			})
+		case messaging_api.AudioMessage:
+			histories = append(histories, models.TmpHistory{
+				Message: models.AudioMessage{
+					Url:      m.OriginalContentUrl,
+					Duration: m.Duration,
+				},
+			})
+		default:
+			raw, err := marshalMessage(message)
+			if err != nil {
+				return nil, err
+			}
+			histories = append(histories, models.TmpHistory{
+				Message: models.RawMessage{
+					JSON: string(raw),
+				},
+			})
+		}
+	}
+	return histories, nil
+}
+
+// marshalMessage encodes a message held by value. The SDK defines
+// MarshalJSON, which adds the "type" field, on pointer receivers only.
+func marshalMessage(message messaging_api.MessageInterface) ([]byte, error) {
+	ptr := reflect.New(reflect.TypeOf(message))
+	ptr.Elem().Set(reflect.ValueOf(message))
+	return json.Marshal(ptr.Interface())
+}
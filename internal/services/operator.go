@@ -0,0 +1,275 @@
+package services
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"larn-line/internal/constants"
+	"larn-line/internal/models"
+	"larn-line/internal/store"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/line/line-bot-sdk-go/v8/linebot/messaging_api"
+)
+
+// operatorProtocol is the WebSocket subprotocol of the operator events.
+const operatorProtocol = "larn-operator"
+
+// OperatorAuth lets through requests carrying token as a bearer token.
+// Browsers cannot set headers on a WebSocket, so opening one may instead
+// offer the token as a second subprotocol after operatorProtocol. Either
+// way it stays out of the URL, which is logged.
+func OperatorAuth(token string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		given, ok := bearerToken(c.GetHeader("Authorization"))
+		if !ok && websocket.IsWebSocketUpgrade(c.Request) {
+			given, ok = protocolToken(websocket.Subprotocols(c.Request))
+		}
+
+		if !ok || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+
+		c.Next()
+	}
+}
+
+func bearerToken(header string) (string, bool) {
+	scheme, token, found := strings.Cut(header, " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return "", false
+	}
+	return token, true
+}
+
+func protocolToken(protocols []string) (string, bool) {
+	if len(protocols) != 2 || protocols[0] != operatorProtocol || protocols[1] == "" {
+		return "", false
+	}
+	return protocols[1], true
+}
+
+type conversation struct {
+	UserId string     `json:"userId"`
+	Since  time.Time  `json:"since"`
+	Last   *time.Time `json:"lastMessageAt,omitempty"`
+}
+
+// OperatorConversations lists the users waiting for or talking to an
+// operator, longest waiting first.
+func (app *LineService) OperatorConversations(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	userIds, err := app.store.ListUsersByMode(ctx, models.ModeHuman)
+	if err != nil {
+		log.Printf("Cannot list conversations: %+v\n", err)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	conversations := make([]conversation, 0, len(userIds))
+	for _, userId := range userIds {
+		user, err := app.store.GetUser(ctx, userId)
+		if err != nil {
+			log.Printf("Cannot get user %s: %+v\n", userId, err)
+			continue
+		}
+
+		conv := conversation{
+			UserId: userId,
+			Since:  user.ModeChangedAt,
+		}
+
+		histories, err := app.store.GetHistory(ctx, userId, user.SessionId)
+		if err != nil {
+			log.Printf("Cannot get history of %s: %+v\n", userId, err)
+		}
+		for _, history := range histories {
+			if conv.Last == nil || history.Timestamp.After(*conv.Last) {
+				timestamp := history.Timestamp
+				conv.Last = &timestamp
+			}
+		}
+
+		conversations = append(conversations, conv)
+	}
+
+	sort.Slice(conversations, func(i, j int) bool {
+		return conversations[i].Since.Before(conversations[j].Since)
+	})
+
+	c.JSON(http.StatusOK, conversations)
+}
+
+// OperatorHistory returns the turns of the user's current session, or 404
+// for a user the bot does not know.
+func (app *LineService) OperatorHistory(c *gin.Context) {
+	user, err := app.store.GetUser(c.Request.Context(), c.Param("userId"))
+	if errors.Is(err, store.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "unknown user"})
+		return
+	}
+	if err != nil {
+		log.Printf("Cannot get user %s: %+v\n", c.Param("userId"), err)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	histories, err := app.store.GetHistory(c.Request.Context(), c.Param("userId"), user.SessionId)
+	if err != nil {
+		log.Printf("Cannot get history of %s: %+v\n", c.Param("userId"), err)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	sort.SliceStable(histories, func(i, j int) bool {
+		return histories[i].Timestamp.Before(histories[j].Timestamp)
+	})
+
+	c.JSON(http.StatusOK, histories)
+}
+
+type operatorReply struct {
+	Text string `json:"text" binding:"required"`
+}
+
+// OperatorReply pushes the operator's text to a user in human mode.
+func (app *LineService) OperatorReply(c *gin.Context) {
+	userId := c.Param("userId")
+
+	var reply operatorReply
+	if err := c.ShouldBindJSON(&reply); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !app.isHumanMode(userId) {
+		c.JSON(http.StatusConflict, gin.H{"error": "conversation is not in human mode"})
+		return
+	}
+
+	if err := app.pushText(userId, reply.Text, nil); err != nil {
+		log.Printf("Cannot push to %s: %+v\n", userId, err)
+		c.Status(http.StatusBadGateway)
+		return
+	}
+
+	sessionId, err := app.currentSession(c.Request.Context(), userId)
+	if err != nil {
+		log.Printf("Cannot get session of %s: %+v\n", userId, err)
+	}
+
+	now := time.Now()
+	if err := app.store.AddHistory(c.Request.Context(), userId, sessionId, models.History{
+		From:      "operator",
+		Message:   reply.Text,
+		Timestamp: now,
+	}); err != nil {
+		log.Printf("Cannot save reply to %s: %+v\n", userId, err)
+	}
+
+	app.operators.publish(OperatorEvent{
+		Type:      "message",
+		UserId:    userId,
+		From:      "operator",
+		Text:      reply.Text,
+		Timestamp: now,
+	})
+
+	c.Status(http.StatusNoContent)
+}
+
+// OperatorClose hands the conversation back to the bot. Like a reply, it
+// is refused for a conversation no operator is answering.
+func (app *LineService) OperatorClose(c *gin.Context) {
+	userId := c.Param("userId")
+
+	err := app.endHumanMode(userId)
+	if errors.Is(err, errNotHumanMode) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		log.Printf("Cannot close conversation of %s: %+v\n", userId, err)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	if err := app.pushText(userId, constants.BOT_MODE_MESSAGE, app.quickReplies); err != nil {
+		log.Printf("Cannot push to %s: %+v\n", userId, err)
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+var upgrader = websocket.Upgrader{
+	// Operators authenticate with the token rather than by origin.
+	CheckOrigin:  func(r *http.Request) bool { return true },
+	Subprotocols: []string{operatorProtocol},
+}
+
+// OperatorEvents streams OperatorEvent as JSON over a WebSocket.
+func (app *LineService) OperatorEvents(c *gin.Context) {
+	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		log.Printf("Cannot upgrade operator connection: %+v\n", err)
+		return
+	}
+	defer conn.Close()
+
+	events := app.operators.subscribe()
+	defer app.operators.unsubscribe(events)
+
+	// Reading is only to notice the operator going away.
+	ctx, cancel := context.WithCancel(c.Request.Context())
+	defer cancel()
+	go func() {
+		defer cancel()
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	ping := time.NewTicker(30 * time.Second)
+	defer ping.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event := <-events:
+			if err := conn.WriteJSON(event); err != nil {
+				return
+			}
+		case <-ping.C:
+			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (app *LineService) pushText(userId string, text string, quickReply *messaging_api.QuickReply) error {
+	_, err := app.bot.PushMessage(
+		&messaging_api.PushMessageRequest{
+			To: userId,
+			Messages: []messaging_api.MessageInterface{
+				&messaging_api.TextMessage{
+					Text:       text,
+					QuickReply: quickReply,
+				},
+			},
+		},
+		"",
+	)
+	return err
+}
@@ -0,0 +1,135 @@
+package services
+
+import (
+	"context"
+	"larn-line/internal/models"
+	"larn-line/internal/store"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestOperatorClose(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name string
+		mode string
+		want int
+	}{
+		{"human mode", models.ModeHuman, http.StatusNoContent},
+		{"bot mode", models.ModeBot, http.StatusConflict},
+		{"unknown user", "", http.StatusConflict},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			const userId = "U1"
+
+			ctx := context.Background()
+			s := store.NewMemoryStore()
+			if tt.mode != "" {
+				if err := s.UpdateUser(ctx, userId, func(user *models.User) error {
+					user.Mode = tt.mode
+					return nil
+				}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			app := newTestService(t, &recordingLarn{}, s)
+
+			router := gin.New()
+			router.POST("/operator/conversations/:userId/close", app.OperatorClose)
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/operator/conversations/"+userId+"/close", nil))
+			if w.Code != tt.want {
+				t.Fatalf("got status %d, want %d", w.Code, tt.want)
+			}
+
+			if tt.mode == "" {
+				return
+			}
+			user, err := s.GetUser(ctx, userId)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if user.Mode != models.ModeBot {
+				t.Errorf("got mode %q, want %q", user.Mode, models.ModeBot)
+			}
+		})
+	}
+}
+
+func TestOperatorAuth(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	router := gin.New()
+	router.GET("/operator/events", OperatorAuth("secret"), func(c *gin.Context) {
+		c.Status(http.StatusNoContent)
+	})
+
+	websocket := func(protocols string) http.Header {
+		return http.Header{
+			"Connection":             {"Upgrade"},
+			"Upgrade":                {"websocket"},
+			"Sec-Websocket-Protocol": {protocols},
+		}
+	}
+
+	tests := []struct {
+		name   string
+		target string
+		header http.Header
+		want   int
+	}{
+		{"bearer", "/operator/events", http.Header{"Authorization": {"Bearer secret"}}, http.StatusNoContent},
+		{"bearer in lower case", "/operator/events", http.Header{"Authorization": {"bearer secret"}}, http.StatusNoContent},
+		{"wrong token", "/operator/events", http.Header{"Authorization": {"Bearer guess"}}, http.StatusUnauthorized},
+		{"no scheme", "/operator/events", http.Header{"Authorization": {"secret"}}, http.StatusUnauthorized},
+		{"other scheme", "/operator/events", http.Header{"Authorization": {"Basic secret"}}, http.StatusUnauthorized},
+		{"query", "/operator/events?token=secret", nil, http.StatusUnauthorized},
+		{"subprotocol", "/operator/events", websocket("larn-operator, secret"), http.StatusNoContent},
+		{"subprotocol without ours", "/operator/events", websocket("secret"), http.StatusUnauthorized},
+		{"subprotocol on plain request", "/operator/events", http.Header{"Sec-Websocket-Protocol": {"larn-operator, secret"}}, http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			req.Header = tt.header
+			if req.Header == nil {
+				req.Header = http.Header{}
+			}
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			if w.Code != tt.want {
+				t.Errorf("got status %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestOperatorHistoryOfUnknownUser(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	s := store.NewMemoryStore()
+	if err := s.AddHistory(context.Background(), "U1", "", models.History{From: "user", Message: "สวัสดี"}); err != nil {
+		t.Fatal(err)
+	}
+	app := newTestService(t, &recordingLarn{}, s)
+
+	router := gin.New()
+	router.GET("/operator/conversations/:userId/messages", app.OperatorHistory)
+
+	for userId, want := range map[string]int{"U1": http.StatusOK, "U2": http.StatusNotFound} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/operator/conversations/"+userId+"/messages", nil))
+		if w.Code != want {
+			t.Errorf("got status %d for %s, want %d", w.Code, userId, want)
+		}
+	}
+}
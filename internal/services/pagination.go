@@ -0,0 +1,174 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"larn-line/internal/constants"
+	"larn-line/internal/models"
+	"larn-line/internal/render"
+	"larn-line/internal/store"
+	"log"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/line/line-bot-sdk-go/v8/linebot/messaging_api"
+)
+
+// pageSize is the most messages LINE accepts in one reply.
+const pageSize = 5
+
+const readMoreAction = "read_more"
+
+var errNoPage = errors.New("no page to read")
+
+func newAnswerId() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// readMoreQuickReply asks for page of answerId through a postback, so the
+// request cannot be mistaken for a question.
+func readMoreQuickReply(answerId string, page int) *messaging_api.QuickReply {
+	params := url.Values{
+		"answer": {answerId},
+		"page":   {strconv.Itoa(page)},
+	}
+
+	return &messaging_api.QuickReply{
+		Items: []messaging_api.QuickReplyItem{
+			{
+				Action: PostbackAction(constants.READ_MORE, constants.READ_MORE, readMoreAction, params),
+			},
+		},
+	}
+}
+
+// paginate returns the first page of messages and stores the rest as the
+// user's pending answer, replacing pages left from the previous one.
+func (app *LineService) paginate(ctx context.Context, userId string, messages []messaging_api.MessageInterface) ([]messaging_api.MessageInterface, error) {
+	if len(messages) <= pageSize {
+		if err := app.store.DeletePendingAnswer(ctx, userId); err != nil {
+			return nil, storageError("delete pending answer", err)
+		}
+		return messages, nil
+	}
+
+	answer := &models.PendingAnswer{
+		AnswerId: newAnswerId(),
+		Pages:    make([][]models.TmpHistory, 0),
+		NextPage: 1,
+		ExpireAt: time.Now().Add(app.readMoreTTL),
+	}
+
+	for i := pageSize; i < len(messages); i += pageSize {
+		page, err := toTmpHistories(messages[i:min(i+pageSize, len(messages))])
+		if err != nil {
+			return nil, renderingError("save pending answer", err)
+		}
+		answer.Pages = append(answer.Pages, page)
+	}
+
+	if err := app.store.SavePendingAnswer(ctx, userId, answer); err != nil {
+		return nil, storageError("save pending answer", err)
+	}
+
+	first := append([]messaging_api.MessageInterface{}, messages[:pageSize-1]...)
+	first = append(first, render.WithQuickReply(messages[pageSize-1], readMoreQuickReply(answer.AnswerId, 1)))
+
+	return first, nil
+}
+
+// sendPage replies with a page of the user's pending answer. An empty
+// answerId means the next unread page of the latest answer, which is what
+// typing "อ่านต่อ" asks for.
+func (app *LineService) sendPage(userId string, answerId string, page int, replyToken string) error {
+	ctx := context.Background()
+
+	var histories []models.TmpHistory
+	hasMore := false
+
+	err := app.store.UpdatePendingAnswer(ctx, userId, func(answer *models.PendingAnswer) error {
+		if time.Now().After(answer.ExpireAt) || (answerId != "" && answer.AnswerId != answerId) {
+			return errNoPage
+		}
+
+		if answerId == "" {
+			answerId = answer.AnswerId
+			page = answer.NextPage
+		}
+
+		if page < 1 || page > len(answer.Pages) {
+			return errNoPage
+		}
+
+		histories = answer.Pages[page-1]
+		hasMore = page < len(answer.Pages)
+		answer.NextPage = page + 1
+
+		return nil
+	})
+
+	if err != nil && !errors.Is(err, errNoPage) && !errors.Is(err, store.ErrNotFound) {
+		return storageError("get pending answer", err)
+	}
+
+	messages := fromTmpHistories(histories)
+
+	if len(messages) == 0 {
+		messages = append(messages, messaging_api.TextMessage{
+			Text: constants.READ_MORE_EMPTY_MESSAGE,
+		})
+	}
+
+	quickReply := app.quickRepliesFor(userId)
+	if hasMore {
+		quickReply = readMoreQuickReply(answerId, page+1)
+	}
+	messages[len(messages)-1] = render.WithQuickReply(messages[len(messages)-1], quickReply)
+
+	if _, err := app.bot.ReplyMessage(
+		&messaging_api.ReplyMessageRequest{
+			ReplyToken: replyToken,
+			Messages:   messages,
+		},
+	); err != nil {
+		log.Print(err)
+	} else {
+		log.Println("Sent text reply.")
+	}
+	return nil
+}
+
+func fromTmpHistories(histories []models.TmpHistory) []messaging_api.MessageInterface {
+	messages := make([]messaging_api.MessageInterface, 0, len(histories))
+	for _, history := range histories {
+		switch h := history.Message.(type) {
+		case models.TextMessage:
+			messages = append(messages, messaging_api.TextMessage{
+				Text: h.GetText(),
+			})
+		case models.ImageMessage:
+			messages = append(messages, messaging_api.ImageMessage{
+				PreviewImageUrl:    h.GetPreview(),
+				OriginalContentUrl: h.GetOriginal(),
+			})
+		case models.AudioMessage:
+			messages = append(messages, messaging_api.AudioMessage{
+				OriginalContentUrl: h.GetUrl(),
+				Duration:           h.GetDuration(),
+			})
+		case models.RawMessage:
+			message, err := messaging_api.UnmarshalMessage([]byte(h.GetJSON()))
+			if err != nil {
+				log.Printf("Cannot decode pending message: %+v\n", err)
+				continue
+			}
+			messages = append(messages, message)
+		}
+	}
+	return messages
+}
@@ -0,0 +1,125 @@
+package services
+
+import (
+	"log"
+	"net/url"
+	"strconv"
+
+	"github.com/line/line-bot-sdk-go/v8/linebot/messaging_api"
+	"github.com/line/line-bot-sdk-go/v8/linebot/webhook"
+)
+
+// Postback is a postback event decoded from its data, which is a query
+// string holding the action name and its parameters.
+type Postback struct {
+	UserId     string
+	ReplyToken string
+	Action     string
+	Params     url.Values
+	// Picked holds what the user chose in a DatetimePickerAction, under
+	// "date", "time" or "datetime" depending on the picker mode.
+	Picked map[string]string
+}
+
+// Int returns the integer parameter key, or 0 when it is missing or invalid.
+func (p Postback) Int(key string) int {
+	n, _ := strconv.Atoi(p.Params.Get(key))
+	return n
+}
+
+type PostbackHandler func(postback Postback) error
+
+// PostbackRouter dispatches postbacks to the handler registered for their
+// action. Handlers are registered before the service starts taking events.
+type PostbackRouter struct {
+	handlers map[string]PostbackHandler
+}
+
+func NewPostbackRouter() *PostbackRouter {
+	return &PostbackRouter{
+		handlers: make(map[string]PostbackHandler),
+	}
+}
+
+func (r *PostbackRouter) Handle(action string, handler PostbackHandler) {
+	r.handlers[action] = handler
+}
+
+// Dispatch runs the handler for the postback's action. Postbacks nobody
+// handles are logged and dropped, since they usually come from buttons sent
+// by an older version of the bot.
+func (r *PostbackRouter) Dispatch(postback Postback) error {
+	handler, ok := r.handlers[postback.Action]
+	if !ok {
+		log.Printf("Unsupported postback action: %q\n", postback.Action)
+		return nil
+	}
+	return handler(postback)
+}
+
+// parsePostback decodes the data of e. Data that is not a query string has
+// no action and is dropped by Dispatch.
+func parsePostback(userId string, e webhook.PostbackEvent) Postback {
+	params, err := url.ParseQuery(e.Postback.Data)
+	if err != nil {
+		log.Printf("Cannot parse postback data %q: %+v\n", e.Postback.Data, err)
+	}
+
+	action := params.Get("action")
+	params.Del("action")
+
+	return Postback{
+		UserId:     userId,
+		ReplyToken: e.ReplyToken,
+		Action:     action,
+		Params:     params,
+		Picked:     e.Postback.Params,
+	}
+}
+
+// postbackData encodes action and params as postback data.
+func postbackData(action string, params url.Values) string {
+	data := url.Values{"action": {action}}
+	for key, values := range params {
+		data[key] = values
+	}
+	return data.Encode()
+}
+
+// PostbackAction makes a button that sends action silently, showing
+// displayText in the chat when it is not empty.
+func PostbackAction(label string, displayText string, action string, params url.Values) *messaging_api.PostbackAction {
+	return &messaging_api.PostbackAction{
+		Label:       label,
+		Data:        postbackData(action, params),
+		DisplayText: displayText,
+	}
+}
+
+// DatetimePickerAction makes a button that asks for a date, a time or both
+// and sends the choice to action.
+func DatetimePickerAction(label string, mode messaging_api.DatetimePickerActionMODE, action string, params url.Values) *messaging_api.DatetimePickerAction {
+	return &messaging_api.DatetimePickerAction{
+		Label: label,
+		Data:  postbackData(action, params),
+		Mode:  mode,
+	}
+}
+
+func (app *LineService) registerPostbacks() {
+	app.postbacks.Handle(readMoreAction, func(p Postback) error {
+		return app.sendPage(p.UserId, p.Params.Get("answer"), p.Int("page"), p.ReplyToken)
+	})
+	app.postbacks.Handle(readAloudAction, func(p Postback) error {
+		return app.setReadAloud(p.UserId, p.Params.Get("on") == "true", p.ReplyToken)
+	})
+	app.postbacks.Handle(consentLinkAction, func(p Postback) error {
+		return app.consentLink(p.UserId, p.ReplyToken)
+	})
+	app.postbacks.Handle(acceptHandoffAction, func(p Postback) error {
+		return app.acceptHandoff(p.UserId, p.Params.Get("volunteer"), p.ReplyToken)
+	})
+	app.postbacks.Handle(resumeSessionAction, func(p Postback) error {
+		return app.resumeSession(p.UserId, p.Params.Get("session"), p.ReplyToken)
+	})
+}
@@ -0,0 +1,139 @@
+package services
+
+import (
+	"hash/fnv"
+	"log"
+	"sync"
+	"sync/atomic"
+
+	"github.com/line/line-bot-sdk-go/v8/linebot/webhook"
+)
+
+// EventQueue is a bounded in-process queue drained by a fixed pool of
+// workers. Events with the same key always go to the same worker, so the
+// events of one chat are handled in the order LINE delivered them.
+type EventQueue struct {
+	shards   []chan webhook.EventInterface
+	handler  func(webhook.EventInterface)
+	capacity int
+
+	mu     sync.RWMutex
+	closed bool
+	wg     sync.WaitGroup
+
+	enqueued  atomic.Int64
+	processed atomic.Int64
+	dropped   atomic.Int64
+}
+
+type QueueStats struct {
+	Workers   int   `json:"workers"`
+	Capacity  int   `json:"capacity"`
+	Depth     int   `json:"depth"`
+	Enqueued  int64 `json:"enqueued"`
+	Processed int64 `json:"processed"`
+	Dropped   int64 `json:"dropped"`
+}
+
+func NewEventQueue(workers int, size int, handler func(webhook.EventInterface)) *EventQueue {
+	if workers < 1 {
+		workers = 1
+	}
+
+	perWorker := (size + workers - 1) / workers
+	if perWorker < 1 {
+		perWorker = 1
+	}
+
+	q := &EventQueue{
+		shards:   make([]chan webhook.EventInterface, workers),
+		handler:  handler,
+		capacity: perWorker * workers,
+	}
+
+	for i := range q.shards {
+		q.shards[i] = make(chan webhook.EventInterface, perWorker)
+		q.wg.Add(1)
+		go q.work(q.shards[i])
+	}
+
+	return q
+}
+
+// Enqueue never blocks. It returns false when the worker owning key is
+// backed up, in which case the event is dropped and counted.
+func (q *EventQueue) Enqueue(key string, event webhook.EventInterface) bool {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	if q.closed {
+		q.dropped.Add(1)
+		return false
+	}
+
+	select {
+	case q.shards[q.shardFor(key)] <- event:
+		q.enqueued.Add(1)
+		return true
+	default:
+		q.dropped.Add(1)
+		return false
+	}
+}
+
+// Close stops accepting events and waits for the queued ones to finish.
+func (q *EventQueue) Close() {
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return
+	}
+	q.closed = true
+	for _, shard := range q.shards {
+		close(shard)
+	}
+	q.mu.Unlock()
+
+	q.wg.Wait()
+}
+
+func (q *EventQueue) Stats() QueueStats {
+	depth := 0
+	for _, shard := range q.shards {
+		depth += len(shard)
+	}
+
+	return QueueStats{
+		Workers:   len(q.shards),
+		Capacity:  q.capacity,
+		Depth:     depth,
+		Enqueued:  q.enqueued.Load(),
+		Processed: q.processed.Load(),
+		Dropped:   q.dropped.Load(),
+	}
+}
+
+func (q *EventQueue) shardFor(key string) int {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % uint32(len(q.shards)))
+}
+
+func (q *EventQueue) work(events <-chan webhook.EventInterface) {
+	defer q.wg.Done()
+
+	for event := range events {
+		q.handle(event)
+		q.processed.Add(1)
+	}
+}
+
+func (q *EventQueue) handle(event webhook.EventInterface) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Recovered while handling %s event: %v\n", event.GetType(), r)
+		}
+	}()
+
+	q.handler(event)
+}
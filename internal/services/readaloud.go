@@ -0,0 +1,172 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"larn-line/internal/constants"
+	"larn-line/internal/models"
+	"larn-line/internal/store"
+	"log"
+	"net/url"
+	"strconv"
+	"sync"
+
+	"github.com/line/line-bot-sdk-go/v8/linebot/messaging_api"
+)
+
+const readAloudAction = "read_aloud"
+
+// readAloudQuickReply offers to turn read aloud on or off without typing.
+func readAloudQuickReply(enabled bool) *messaging_api.QuickReplyItem {
+	label := constants.READ_ALOUD_OFF
+	if enabled {
+		label = constants.READ_ALOUD_ON
+	}
+
+	return &messaging_api.QuickReplyItem{
+		Action: PostbackAction(label, label, readAloudAction, url.Values{"on": {strconv.FormatBool(enabled)}}),
+	}
+}
+
+func (app *LineService) setReadAloud(userId string, enabled bool, replyToken string) error {
+	if err := app.store.UpdateUser(context.Background(), userId, func(user *models.User) error {
+		user.ReadAloud = enabled
+		return nil
+	}); err != nil {
+		return storageError("update user", err)
+	}
+
+	text := constants.READ_ALOUD_OFF_MESSAGE
+	if enabled {
+		text = constants.READ_ALOUD_ON_MESSAGE
+	}
+
+	if _, err := app.bot.ReplyMessage(
+		&messaging_api.ReplyMessageRequest{
+			ReplyToken: replyToken,
+			Messages: []messaging_api.MessageInterface{
+				&messaging_api.TextMessage{
+					Text: text,
+					QuickReply: app.addressed(userId, &messaging_api.QuickReply{
+						Items: append([]messaging_api.QuickReplyItem{*readAloudQuickReply(!enabled)}, app.quickReplies.Items...),
+					}),
+				},
+			},
+		},
+	); err != nil {
+		log.Print(err)
+	}
+
+	return nil
+}
+
+// readAloud adds a voice message after every text message for users who
+// turned read aloud on.
+func (app *LineService) readAloud(ctx context.Context, userId string, messages []messaging_api.MessageInterface) []messaging_api.MessageInterface {
+	if !app.readsAloud(ctx, userId) {
+		return messages
+	}
+	return app.speakAll(ctx, messages)
+}
+
+// readsAloud reports whether answers to userId are read aloud.
+func (app *LineService) readsAloud(ctx context.Context, userId string) bool {
+	if app.textToSpeech == nil || app.objects == nil {
+		return false
+	}
+
+	user, err := app.store.GetUser(ctx, userId)
+	if err != nil {
+		if !errors.Is(err, store.ErrNotFound) {
+			log.Printf("Cannot get user %s: %+v\n", userId, err)
+		}
+		return false
+	}
+
+	return user.ReadAloud
+}
+
+// spokenLength is how many messages speakAll makes of messages when every
+// audio can be made.
+func (app *LineService) spokenLength(messages []messaging_api.MessageInterface) int {
+	if app.readAloudOnly {
+		return len(messages)
+	}
+
+	n := len(messages)
+	for _, message := range messages {
+		if _, ok := message.(messaging_api.TextMessage); ok {
+			n++
+		}
+	}
+	return n
+}
+
+// speakAll makes a voice message of every text message. A text message
+// whose audio cannot be made is sent as is, even when voice is meant to
+// replace text.
+func (app *LineService) speakAll(ctx context.Context, messages []messaging_api.MessageInterface) []messaging_api.MessageInterface {
+	audios := make([]*messaging_api.AudioMessage, len(messages))
+
+	var wg sync.WaitGroup
+	for i, message := range messages {
+		m, ok := message.(messaging_api.TextMessage)
+		if !ok {
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			audio, err := app.speak(ctx, m.Text)
+			if err != nil {
+				log.Printf("Cannot read message aloud: %+v\n", err)
+				return
+			}
+
+			audio.QuickReply = m.QuickReply
+			audios[i] = audio
+		}()
+	}
+	wg.Wait()
+
+	spoken := make([]messaging_api.MessageInterface, 0, len(messages)*2)
+	for i, message := range messages {
+		if audios[i] == nil {
+			spoken = append(spoken, message)
+			continue
+		}
+
+		if !app.readAloudOnly {
+			spoken = append(spoken, message)
+		}
+		spoken = append(spoken, *audios[i])
+	}
+
+	return spoken
+}
+
+// speak synthesizes text and stores the audio under a hash of it, so that
+// an answer given again overwrites its earlier audio instead of adding
+// another object.
+func (app *LineService) speak(ctx context.Context, text string) (*messaging_api.AudioMessage, error) {
+	audio, duration, err := app.textToSpeech.Synthesize(ctx, text)
+	if err != nil {
+		return nil, upstreamError("synthesize speech", err)
+	}
+
+	sum := sha256.Sum256([]byte(text))
+
+	url, err := app.objects.Put(ctx, "tts/"+hex.EncodeToString(sum[:])+".m4a", audio, "audio/x-m4a")
+	if err != nil {
+		return nil, storageError("store speech", err)
+	}
+
+	return &messaging_api.AudioMessage{
+		OriginalContentUrl: url,
+		Duration:           duration.Milliseconds(),
+	}, nil
+}
@@ -1,75 +1,197 @@
 package services
 
 import (
-	"bytes"
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
-	"io"
+	"fmt"
+	"larn-line/internal/models"
+	"larn-line/internal/utils"
 	"log"
-	"net/http"
 	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/line/line-bot-sdk-go/v8/linebot/messaging_api"
 )
 
-func GetRecommend(message string) ([]string, error) {
+func (l *httpLarnClient) Recommend(ctx context.Context, message string) ([]string, error) {
 
 	payload := map[string]any{
 		"message": message,
 	}
 
-	marshalled, err := json.Marshal(payload)
+	type Response struct {
+		Response []string `json:"response"`
+	}
 
-	if err != nil {
-		log.Fatal("Cannot marshal json message")
+	var apiRes Response
+
+	if err := l.post(ctx, "/ai/recommend", payload, &apiRes); err != nil {
+		return nil, upstreamError("get recommend", err)
 	}
 
-	url := os.Getenv("LARN_API_URL") + "/ai/recommend"
+	var finalRecommends []string
 
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(marshalled))
+	for i, recommend := range apiRes.Response {
+		if i > 12 {
+			break
+		}
 
-	req.Header.Set("Content-Type", "application/json")
+		if len(recommend) != 0 {
+			finalRecommends = append(finalRecommends, recommend)
+		}
+	}
 
+	return finalRecommends, nil
+}
+
+// LoadSuggestions reads a JSON object of static follow-up questions by
+// classification, offered when Larn's recommendations do not arrive in
+// time.
+func LoadSuggestions(path string) (map[string][]string, error) {
+	data, err := os.ReadFile(path)
 	if err != nil {
-		log.Fatal("Request Failed")
+		return nil, err
 	}
 
-	client := &http.Client{}
+	var suggestions map[string][]string
+	if err := json.Unmarshal(data, &suggestions); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
 
-	res, err := client.Do(req)
+	return suggestions, nil
+}
 
-	if err != nil {
-		log.Fatal(err)
+// recommendCache keeps the latest recommendations by a hash of the answer
+// they were made for, evicting the least recently used.
+type recommendCache struct {
+	mu       sync.Mutex
+	capacity int
+	order    *list.List
+	entries  map[string]*list.Element
+}
+
+type cachedRecommends struct {
+	key        string
+	recommends []string
+}
+
+func newRecommendCache(capacity int) *recommendCache {
+	return &recommendCache{
+		capacity: capacity,
+		order:    list.New(),
+		entries:  make(map[string]*list.Element),
 	}
+}
 
-	defer res.Body.Close()
+func (c *recommendCache) get(key string) ([]string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
-	body, err := io.ReadAll(res.Body)
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
 
-	if err != nil {
-		log.Fatal(err)
+	c.order.MoveToFront(el)
+	return el.Value.(*cachedRecommends).recommends, true
+}
+
+func (c *recommendCache) put(key string, recommends []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.capacity <= 0 {
+		return
 	}
 
-	type Response struct {
-		Response []string `json:"response"`
+	if el, ok := c.entries[key]; ok {
+		el.Value.(*cachedRecommends).recommends = recommends
+		c.order.MoveToFront(el)
+		return
 	}
 
-	var apiRes Response
+	c.entries[key] = c.order.PushFront(&cachedRecommends{key: key, recommends: recommends})
 
-	err = json.Unmarshal(body, &apiRes)
+	for c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cachedRecommends).key)
+	}
+}
 
-	if err != nil {
-		return nil, err
+// recommendRequestTimeout bounds a request for recommendations left running
+// after the answer went out without them.
+const recommendRequestTimeout = 30 * time.Second
+
+// recommend starts fetching follow-up questions for an answer and returns a
+// function that waits for them until the recommend timeout, counted from
+// now rather than from the wait, which comes after rendering the answer. A
+// request still running then is left to finish, so that its result is
+// cached for the next time the same answer is given.
+func (app *LineService) recommend(res *models.Message) func() *messaging_api.QuickReply {
+	sum := sha256.Sum256([]byte(res.Response))
+	key := hex.EncodeToString(sum[:])
+
+	if recommends, ok := app.recommends.get(key); ok {
+		return func() *messaging_api.QuickReply {
+			return utils.CreateQuickReply(recommends)
+		}
 	}
 
-	var finalRecommends []string
+	deadline := time.Now().Add(app.recommendTimeout)
+	done := make(chan []string, 1)
 
-	for i, recommend := range apiRes.Response {
-		if i > 12 {
-			break
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), recommendRequestTimeout)
+		defer cancel()
+
+		recommends, err := app.larn.Recommend(ctx, res.Response)
+		if err != nil {
+			log.Printf("Cannot get recommendations: %+v\n", err)
+		}
+		if len(recommends) > 0 {
+			app.recommends.put(key, recommends)
+		}
+		done <- recommends
+	}()
+
+	return func() *messaging_api.QuickReply {
+		var recommends []string
+
+		// Recommendations that came while the answer was rendered are taken
+		// even when the deadline has passed.
+		select {
+		case recommends = <-done:
+		default:
+			timer := time.NewTimer(time.Until(deadline))
+			defer timer.Stop()
+
+			select {
+			case recommends = <-done:
+			case <-timer.C:
+				log.Println("Recommendations are late, using suggestions.")
+			}
 		}
 
-		if len(recommend) != 0 {
-			finalRecommends = append(finalRecommends, recommend)
+		if len(recommends) > 0 {
+			return utils.CreateQuickReply(recommends)
 		}
+		return app.fallbackQuickReply(res.Classification)
 	}
+}
 
-	return finalRecommends, nil
+// fallbackQuickReply offers the static suggestions for the classification,
+// or the default quick replies.
+func (app *LineService) fallbackQuickReply(classification string) *messaging_api.QuickReply {
+	if suggestions := app.suggestions[classification]; len(suggestions) > 0 {
+		return utils.CreateQuickReply(suggestions)
+	}
+	// A copy, since the SDK writes to the items while marshalling them and
+	// the defaults go out with many replies at once.
+	return &messaging_api.QuickReply{Items: slices.Clone(app.quickReplies.Items)}
 }
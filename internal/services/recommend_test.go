@@ -0,0 +1,68 @@
+package services
+
+import (
+	"context"
+	"larn-line/internal/models"
+	"larn-line/internal/store"
+	"testing"
+	"time"
+
+	"github.com/line/line-bot-sdk-go/v8/linebot/messaging_api"
+)
+
+// slowLarn recommends after a delay, noting whether it was given a
+// deadline.
+type slowLarn struct {
+	recordingLarn
+	delay       time.Duration
+	hasDeadline chan bool
+}
+
+func (l *slowLarn) Recommend(ctx context.Context, message string) ([]string, error) {
+	_, ok := ctx.Deadline()
+	l.hasDeadline <- ok
+
+	time.Sleep(l.delay)
+	return []string{"ถามต่อ"}, nil
+}
+
+func TestRecommendDeadline(t *testing.T) {
+	tests := []struct {
+		name string
+		// render is how long the answer takes to render before waiting.
+		render time.Duration
+		delay  time.Duration
+		want   string
+	}{
+		{"in time", 0, 10 * time.Millisecond, "ถามต่อ"},
+		{"came while rendering", 100 * time.Millisecond, 10 * time.Millisecond, "ถามต่อ"},
+		{"late", 100 * time.Millisecond, time.Second, "default"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			larn := &slowLarn{delay: tt.delay, hasDeadline: make(chan bool, 1)}
+			app := newTestService(t, larn, store.NewMemoryStore())
+			app.recommendTimeout = 50 * time.Millisecond
+			app.quickReplies = &messaging_api.QuickReply{Items: []messaging_api.QuickReplyItem{
+				{Action: &messaging_api.MessageAction{Label: "default", Text: "default"}},
+			}}
+
+			waitRecommends := app.recommend(&models.Message{Response: "answer " + tt.name})
+			time.Sleep(tt.render)
+
+			start := time.Now()
+			quickReply := waitRecommends()
+			if waited := time.Since(start); waited > app.recommendTimeout {
+				t.Errorf("waited %v after rendering, want at most %v", waited, app.recommendTimeout)
+			}
+
+			if got := quickReply.Items[0].Action.(*messaging_api.MessageAction).Text; got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+			if !<-larn.hasDeadline {
+				t.Error("recommendations were requested without a deadline")
+			}
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package services
+
+import (
+	"larn-line/internal/constants"
+	"larn-line/internal/scamcheck"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/line/line-bot-sdk-go/v8/linebot/messaging_api"
+)
+
+// scamVerdict checks the links and phone numbers in text against the local
+// lists. It returns nil when nothing is known about them.
+func (app *LineService) scamVerdict(text string) messaging_api.MessageInterface {
+	if app.scamChecker == nil {
+		return nil
+	}
+
+	report := app.scamChecker.Check(text)
+
+	var header string
+	switch report.Level() {
+	case scamcheck.Dangerous:
+		header = constants.SCAM_DANGEROUS_MESSAGE
+	case scamcheck.Suspicious:
+		header = constants.SCAM_SUSPICIOUS_MESSAGE
+	case scamcheck.Safe:
+		// Vouching for the listed links would read as vouching for the
+		// others in the message too.
+		for _, f := range report.Findings {
+			if f.Level != scamcheck.Safe {
+				return nil
+			}
+		}
+		header = constants.SCAM_SAFE_MESSAGE
+	default:
+		return nil
+	}
+
+	lines := []string{header}
+	for _, f := range report.Findings {
+		if f.Level != report.Level() {
+			continue
+		}
+
+		line := "• " + f.Value
+		switch {
+		case f.Lookalike != "":
+			line += " " + constants.SCAM_LOOKALIKE + " " + f.Lookalike
+		case f.Shortened:
+			line += " " + constants.SCAM_SHORTENED
+		case f.Reason != "":
+			line += " (" + f.Reason + ")"
+		}
+		lines = append(lines, line)
+	}
+
+	return messaging_api.TextMessage{
+		Text: strings.Join(lines, "\n"),
+	}
+}
+
+// OperatorReloadScamLists reads the block and allow lists again without
+// waiting for the files to be noticed as changed.
+func (app *LineService) OperatorReloadScamLists(c *gin.Context) {
+	if app.scamChecker == nil {
+		c.Status(http.StatusNotFound)
+		return
+	}
+
+	if err := app.scamChecker.Reload(); err != nil {
+		log.Printf("Cannot reload scam lists: %+v\n", err)
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
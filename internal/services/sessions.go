@@ -0,0 +1,255 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"larn-line/internal/constants"
+	"larn-line/internal/models"
+	"larn-line/internal/rules"
+	"larn-line/internal/store"
+	"log"
+	"net/url"
+	"time"
+
+	"github.com/line/line-bot-sdk-go/v8/linebot/messaging_api"
+)
+
+// AgentSwitchPolicy decides what happens to the conversation when Larn
+// classifies a question to a different agent than the one before.
+type AgentSwitchPolicy string
+
+const (
+	// NewSession moves the question to a new session. The old one can be
+	// resumed.
+	NewSession AgentSwitchPolicy = "new"
+	// KeepSession carries on in the same session.
+	KeepSession AgentSwitchPolicy = "keep"
+)
+
+const resumeSessionAction = "resume_session"
+
+// previousTopics is how many earlier sessions are offered to resume, one
+// quick reply each.
+const previousTopics = 5
+
+// getUser returns the stored user, or an empty one for a user not stored
+// yet.
+func (app *LineService) getUser(ctx context.Context, userId string) (*models.User, error) {
+	user, err := app.store.GetUser(ctx, userId)
+	if errors.Is(err, store.ErrNotFound) {
+		return &models.User{}, nil
+	}
+	if err != nil {
+		return nil, storageError("get user", err)
+	}
+	return user, nil
+}
+
+// currentSession returns the id of the session the user is in. Users from
+// before sessions are in the empty one.
+func (app *LineService) currentSession(ctx context.Context, userId string) (string, error) {
+	user, err := app.getUser(ctx, userId)
+	if err != nil {
+		return "", err
+	}
+	return user.SessionId, nil
+}
+
+// moveSession starts a session for the question when the user has none,
+// asked for a new topic or, under the NewSession policy, when the question
+// went to another agent. It returns nil when the question stays in the
+// current session.
+//
+// Users from before sessions have none. Their earlier turns go to the new
+// session, or to one of their own when the question starts a new topic, so
+// that they can still be resumed.
+func (app *LineService) moveSession(user *models.User, text string, agent string) (*models.Session, *models.Session) {
+	previous := user.CurrentAgent
+	switched := previous != "" && previous != agent
+	user.CurrentAgent = agent
+
+	moved := user.NewTopic || (switched && app.agentSwitch == NewSession)
+	if user.SessionId != "" && !moved {
+		return nil, nil
+	}
+
+	now := time.Now()
+	session := &models.Session{
+		Id:        newAnswerId(),
+		Agent:     agent,
+		Title:     rules.Excerpt(text),
+		StartedAt: now,
+	}
+
+	var legacy *models.Session
+	if user.SessionId == "" {
+		legacy = session
+		if moved {
+			legacy = &models.Session{
+				Id:      newAnswerId(),
+				Agent:   previous,
+				Title:   constants.EARLIER_TOPIC_TITLE,
+				EndedAt: &now,
+			}
+		}
+	}
+	user.SessionId, user.NewTopic = session.Id, false
+
+	return session, legacy
+}
+
+// endSession marks a session as left. Failing to is only logged, since the
+// session can still be resumed without its end time.
+func (app *LineService) endSession(ctx context.Context, userId string, sessionId string, now time.Time) {
+	if sessionId == "" {
+		return
+	}
+
+	session, err := app.store.GetSession(ctx, userId, sessionId)
+	if err == nil {
+		session.EndedAt = &now
+		err = app.store.SaveSession(ctx, userId, *session)
+	}
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		log.Printf("Cannot end session %s of %s: %+v\n", sessionId, userId, err)
+	}
+}
+
+// startNewTopic clears the history sent with the next question, which then
+// starts a session of its own.
+func (app *LineService) startNewTopic(userId string, replyToken string) error {
+	release := app.turns.lock(userId)
+	defer release()
+
+	if err := app.store.UpdateUser(context.Background(), userId, func(user *models.User) error {
+		user.NewTopic = true
+		return nil
+	}); err != nil {
+		return storageError("update user", err)
+	}
+
+	app.replyWithQuickReply(userId, replyToken, constants.NEW_TOPIC_MESSAGE, &messaging_api.QuickReplyItem{
+		Action: &messaging_api.MessageAction{Label: constants.PREVIOUS_TOPICS, Text: constants.PREVIOUS_TOPICS},
+	})
+
+	return nil
+}
+
+// listPreviousTopics offers the latest sessions other than the current one
+// to resume.
+func (app *LineService) listPreviousTopics(userId string, replyToken string) error {
+	ctx := context.Background()
+
+	user, err := app.getUser(ctx, userId)
+	if err != nil {
+		return err
+	}
+	// After "new topic" the session the user was in is a previous one.
+	current := user.SessionId
+	if user.NewTopic {
+		current = ""
+	}
+
+	sessions, err := app.store.ListSessions(ctx, userId, previousTopics+1)
+	if err != nil {
+		return storageError("list sessions", err)
+	}
+
+	items := make([]*messaging_api.QuickReplyItem, 0, previousTopics)
+	for _, session := range sessions {
+		if session.Id == current || session.Title == "" || len(items) == previousTopics {
+			continue
+		}
+		items = append(items, &messaging_api.QuickReplyItem{
+			Action: PostbackAction(topicLabel(session.Title), session.Title, resumeSessionAction, url.Values{"session": {session.Id}}),
+		})
+	}
+
+	if len(items) == 0 {
+		app.replyChatText(userId, replyToken, constants.NO_PREVIOUS_TOPICS_MESSAGE)
+		return nil
+	}
+
+	app.replyWithQuickReply(userId, replyToken, constants.PREVIOUS_TOPICS_MESSAGE, items...)
+	return nil
+}
+
+// resumeSession makes an earlier session current again, so that its turns
+// and summary are sent with the next question.
+func (app *LineService) resumeSession(userId string, sessionId string, replyToken string) error {
+	release := app.turns.lock(userId)
+	defer release()
+
+	ctx := context.Background()
+	now := time.Now()
+
+	session, err := app.store.GetSession(ctx, userId, sessionId)
+	if errors.Is(err, store.ErrNotFound) {
+		app.replyChatText(userId, replyToken, constants.NO_PREVIOUS_TOPICS_MESSAGE)
+		return nil
+	}
+	if err != nil {
+		return storageError("get session", err)
+	}
+
+	var ended string
+	if err := app.store.UpdateUser(ctx, userId, func(user *models.User) error {
+		ended = user.SessionId
+		user.SessionId = session.Id
+		user.CurrentAgent = session.Agent
+		user.NewTopic = false
+		return nil
+	}); err != nil {
+		return storageError("update user", err)
+	}
+
+	if ended != session.Id {
+		app.endSession(ctx, userId, ended, now)
+	}
+
+	session.EndedAt = nil
+	if err := app.store.SaveSession(ctx, userId, *session); err != nil {
+		log.Printf("Cannot reopen session %s of %s: %+v\n", session.Id, userId, err)
+	}
+
+	app.replyChatText(userId, replyToken, fmt.Sprintf(constants.RESUMED_TOPIC_MESSAGE, session.Title))
+	return nil
+}
+
+// topicLabel fits a session title in a quick reply button.
+func topicLabel(title string) string {
+	runes := []rune(title)
+	if len(runes) > 17 {
+		return string(runes[:17]) + "..."
+	}
+	return title
+}
+
+// replyWithQuickReply replies with text and the given quick replies ahead
+// of the default ones.
+func (app *LineService) replyWithQuickReply(chatId string, replyToken string, text string, items ...*messaging_api.QuickReplyItem) {
+	quickReply := &messaging_api.QuickReply{}
+	for _, item := range items {
+		quickReply.Items = append(quickReply.Items, *item)
+	}
+	quickReply.Items = append(quickReply.Items, app.quickReplies.Items...)
+	if len(quickReply.Items) > 13 {
+		quickReply.Items = quickReply.Items[:13]
+	}
+	quickReply = app.addressed(chatId, quickReply)
+
+	if _, err := app.bot.ReplyMessage(
+		&messaging_api.ReplyMessageRequest{
+			ReplyToken: replyToken,
+			Messages: []messaging_api.MessageInterface{
+				&messaging_api.TextMessage{
+					Text:       text,
+					QuickReply: quickReply,
+				},
+			},
+		},
+	); err != nil {
+		log.Print(err)
+	}
+}
@@ -0,0 +1,300 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"larn-line/internal/constants"
+	"larn-line/internal/markup"
+	"larn-line/internal/models"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/line/line-bot-sdk-go/v8/linebot/messaging_api"
+)
+
+// streamEvent is one line of a streamed answer, either a server-sent event
+// ("data: {...}") or a line of newline-delimited JSON.
+type streamEvent struct {
+	Text           string `json:"text"`
+	Classification string `json:"classification"`
+	// Response is the whole answer, which the last event may repeat.
+	Response string `json:"response"`
+	Done     bool   `json:"done"`
+	Error    string `json:"error"`
+}
+
+// StreamMessage asks "/ai/message/stream" and calls onText with each part of
+// the answer as it is written. It is not retried, since onText may already
+// have been called.
+func (l *httpLarnClient) StreamMessage(ctx context.Context, message string, summary string, history []models.History, onText func(text string)) (*models.Message, error) {
+	payload := map[string]any{
+		"message": message,
+		"history": history,
+	}
+	if summary != "" {
+		payload["summary"] = summary
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	if l.config.StreamTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, l.config.StreamTimeout)
+		defer cancel()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", l.config.BaseURL+"/ai/message/stream", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "text/event-stream, application/x-ndjson")
+
+	// The answer may take longer than the client timeout meant for single
+	// requests, so only the context bounds it.
+	res, err := l.stream.Do(req)
+	if err != nil {
+		return nil, upstreamError("stream larn message", err)
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		resBody, _ := io.ReadAll(res.Body)
+		return nil, upstreamError("stream larn message", &APIError{
+			StatusCode: res.StatusCode,
+			Message:    errorMessage(resBody),
+		})
+	}
+
+	var response strings.Builder
+	answer := &models.Message{}
+
+	scanner := bufio.NewScanner(res.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		// Blank lines end server-sent events and lines starting with a
+		// colon are comments; neither carries data.
+		if line == "" || strings.HasPrefix(line, ":") || strings.HasPrefix(line, "event:") {
+			continue
+		}
+		line = strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		if line == "[DONE]" {
+			break
+		}
+
+		var event streamEvent
+		if err := json.Unmarshal([]byte(line), &event); err != nil {
+			return nil, upstreamError("stream larn message", err)
+		}
+
+		if event.Error != "" {
+			return nil, upstreamError("stream larn message", errors.New(event.Error))
+		}
+		if event.Classification != "" {
+			answer.Classification = event.Classification
+		}
+		if event.Text != "" {
+			response.WriteString(event.Text)
+			onText(event.Text)
+		}
+		if event.Done {
+			if event.Response != "" && event.Response != response.String() {
+				log.Println("Streamed answer differs from the final response, keeping what was streamed.")
+			}
+			break
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, upstreamError("stream larn message", err)
+	}
+
+	answer.Response = response.String()
+	return answer, nil
+}
+
+// quotaRefresh is how long the push quota LINE reported is trusted before
+// asking again.
+const quotaRefresh = 10 * time.Minute
+
+// pushQuota counts down the push messages left this month between checks
+// with LINE.
+type pushQuota struct {
+	mu        sync.Mutex
+	limited   bool
+	remaining int64
+	checkedAt time.Time
+}
+
+// canPush reports whether n more push messages leave the reserve intact.
+// When the quota cannot be read, pushing is avoided.
+func (app *LineService) canPush(n int) bool {
+	q := app.pushQuota
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if time.Since(q.checkedAt) > quotaRefresh {
+		quota, err := app.bot.GetMessageQuota()
+		if err != nil {
+			log.Printf("Cannot get message quota: %+v\n", err)
+			return false
+		}
+
+		q.limited = quota.Type == messaging_api.QuotaType_LIMITED
+		if q.limited {
+			consumption, err := app.bot.GetMessageQuotaConsumption()
+			if err != nil {
+				log.Printf("Cannot get message quota consumption: %+v\n", err)
+				return false
+			}
+			q.remaining = quota.Value - consumption.TotalUsage
+		}
+		q.checkedAt = time.Now()
+	}
+
+	return !q.limited || q.remaining-int64(n) >= app.pushReserve
+}
+
+func (app *LineService) push(userId string, messages []messaging_api.MessageInterface) error {
+	q := app.pushQuota
+	q.mu.Lock()
+	q.remaining--
+	q.mu.Unlock()
+
+	_, err := app.bot.PushMessage(
+		&messaging_api.PushMessageRequest{
+			To:       userId,
+			Messages: messages,
+		},
+		"",
+	)
+	return err
+}
+
+// streamLarnMessage answers like handleLarnMessage, except that the first
+// finished chunk of the answer is replied as soon as it is written and the
+// rest is pushed: finished chunks while there are pushes to spare, and the
+// remainder with its quick replies once the answer is complete. An answer
+// that finishes before any chunk does is replied as usual. finish is called
+// with the complete answer to save the turn.
+func (app *LineService) streamLarnMessage(ctx context.Context, userId string, text string, replyToken string, summary string, histories []models.History, finish func(res *models.Message), leading ...messaging_api.MessageInterface) error {
+	var written strings.Builder
+	sent := 0
+	replied := false
+	pushes := 0
+	// stopped is set when a chunk could not be sent. Later chunks are not
+	// tried, so that no audio is made twice for the same text, and the rest
+	// of the answer goes out once it is complete.
+	stopped := false
+	aloud := app.readsAloud(ctx, userId)
+
+	onText := func(part string) {
+		written.WriteString(part)
+
+		settled := markup.Settled(written.String())
+		if stopped || settled <= sent {
+			return
+		}
+		// The last push is kept for the end of the answer.
+		if replied && pushes+1 >= app.streamPushes {
+			return
+		}
+
+		messages := app.render(written.String()[sent:settled], nil)
+		if len(messages) == 0 {
+			sent = settled
+			return
+		}
+
+		if !replied {
+			messages = append(append([]messaging_api.MessageInterface{}, leading...), messages...)
+		}
+		length := len(messages)
+		if aloud {
+			length = app.spokenLength(messages)
+		}
+		if length > pageSize {
+			// Too long to send at once; it goes out with the rest.
+			return
+		}
+		// Audio is only made for a chunk that is about to be sent.
+		if aloud {
+			messages = app.speakAll(ctx, messages)
+		}
+
+		if !replied {
+			if _, err := app.bot.ReplyMessage(
+				&messaging_api.ReplyMessageRequest{
+					ReplyToken: replyToken,
+					Messages:   messages,
+				},
+			); err != nil {
+				log.Print(err)
+				stopped = true
+				return
+			}
+			replied, sent = true, settled
+			return
+		}
+
+		if err := app.push(userId, messages); err != nil {
+			log.Printf("Cannot push to %s: %+v\n", userId, err)
+			stopped = true
+			return
+		}
+		pushes++
+		sent = settled
+	}
+
+	res, err := app.larn.StreamMessage(ctx, text, summary, histories, onText)
+	if err != nil {
+		if !replied {
+			return err
+		}
+
+		log.Printf("Larn stopped answering %s: %+v\n", userId, err)
+		if err := app.pushText(userId, constants.UPSTREAM_ERROR_MESSAGE, app.quickRepliesFor(userId)); err != nil {
+			log.Printf("Cannot push to %s: %+v\n", userId, err)
+		}
+		return nil
+	}
+
+	finish(res)
+
+	if !replied {
+		return app.replyLarnResponse(ctx, userId, replyToken, res, leading...)
+	}
+
+	rest := res.Response[sent:]
+	if strings.TrimSpace(rest) == "" {
+		return nil
+	}
+
+	messages, err := app.larnMessages(ctx, userId, res, rest)
+	if err != nil {
+		return err
+	}
+
+	if err := app.push(userId, messages); err != nil {
+		log.Printf("Cannot push to %s: %+v\n", userId, err)
+	} else {
+		log.Println("Pushed rest of streamed answer.")
+	}
+
+	return nil
+}
@@ -0,0 +1,69 @@
+package services
+
+import (
+	"context"
+	"larn-line/internal/models"
+	"larn-line/internal/objectstore"
+	"larn-line/internal/store"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// streamingLarn writes its answer in the given parts.
+type streamingLarn struct {
+	recordingLarn
+	parts []string
+}
+
+func (l *streamingLarn) StreamMessage(ctx context.Context, message string, summary string, history []models.History, onText func(text string)) (*models.Message, error) {
+	var response string
+	for _, part := range l.parts {
+		response += part
+		onText(part)
+	}
+	return &models.Message{Classification: "general", Response: response}, nil
+}
+
+type countingSpeech struct {
+	calls atomic.Int32
+}
+
+func (s *countingSpeech) Synthesize(ctx context.Context, text string) ([]byte, time.Duration, error) {
+	s.calls.Add(1)
+	return []byte("audio"), time.Second, nil
+}
+
+func TestStreamReadsEachChunkAloudOnce(t *testing.T) {
+	const userId = "U1"
+
+	ctx := context.Background()
+	s := store.NewMemoryStore()
+	if err := s.UpdateUser(ctx, userId, func(user *models.User) error {
+		user.ReadAloud = true
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The first chunk is replied. The second settles once the push budget
+	// is used up, so it waits for the end of the answer while more is
+	// written.
+	larn := &streamingLarn{parts: []string{"a %%% ", "b %%% ", "c", "d", "e"}}
+	speech := &countingSpeech{}
+
+	app := newTestService(t, larn, s)
+	app.textToSpeech = speech
+	app.objects = objectstore.NewLocalObjectStore(t.TempDir(), "https://example.com")
+	app.streamAnswers, app.streamPushes = true, 1
+
+	if err := app.handleLarnMessage(userId, "question", "token"); err != nil {
+		t.Fatal(err)
+	}
+
+	// One for the reply and one for each of the two messages pushed at the
+	// end.
+	if got := speech.calls.Load(); got != 3 {
+		t.Errorf("synthesized speech %d times, want 3", got)
+	}
+}
@@ -0,0 +1,128 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"larn-line/internal/models"
+	"larn-line/internal/store"
+	"log"
+	"time"
+	"unicode/utf8"
+)
+
+func (l *httpLarnClient) Summarize(ctx context.Context, summary string, history []models.History) (string, error) {
+	payload := map[string]any{
+		"summary": summary,
+		"history": history,
+	}
+
+	var response struct {
+		Summary string `json:"summary"`
+	}
+
+	if err := l.post(ctx, "/ai/summarize", payload, &response); err != nil {
+		return "", upstreamError("summarize history", err)
+	}
+
+	return response.Summary, nil
+}
+
+// estimateTokens is a rough count for budgeting. Thai has no spaces to count
+// words by, so it goes by characters, about three to a token.
+func estimateTokens(text string) int {
+	return (utf8.RuneCountInString(text) + 2) / 3
+}
+
+// historyWindow returns where the most recent turns that fit in the budget
+// start. A turn is a question with everything that answered it, so the
+// window always starts at a question and Larn never sees an answer without
+// what it answered.
+func historyWindow(histories []models.History, turns int, tokens int) int {
+	start, used, questions := len(histories), 0, 0
+
+	for i := len(histories) - 1; i >= 0; i-- {
+		used += estimateTokens(histories[i].Message)
+		if histories[i].From == "user" {
+			questions++
+		}
+		if questions > turns || used > tokens {
+			break
+		}
+		if histories[i].From == "user" {
+			start = i
+		}
+	}
+
+	return start
+}
+
+// loadHistory returns the summary of compacted turns and the recent turns
+// within the budget of the user's current session.
+func (app *LineService) loadHistory(ctx context.Context, userId string) (string, []models.History, error) {
+	user, err := app.getUser(ctx, userId)
+	if err != nil {
+		return "", nil, err
+	}
+	if user.NewTopic {
+		return "", []models.History{}, nil
+	}
+	sessionId := user.SessionId
+
+	histories, err := app.store.GetHistory(ctx, userId, sessionId)
+	if err != nil {
+		return "", nil, storageError("get history", err)
+	}
+
+	summary := ""
+	if s, err := app.store.GetSummary(ctx, userId, sessionId); err == nil {
+		summary = s.Text
+	} else if !errors.Is(err, store.ErrNotFound) {
+		return "", nil, storageError("get summary", err)
+	}
+
+	start := historyWindow(histories, app.historyTurns, app.historyTokens)
+
+	return summary, larnHistory(histories[start:]), nil
+}
+
+// compactHistory folds the turns that no longer fit in the budget into the
+// summary. It keeps only half the budget, so that it runs every few turns
+// rather than on every one.
+func (app *LineService) compactHistory(ctx context.Context, userId string, sessionId string) error {
+	histories, err := app.store.GetHistory(ctx, userId, sessionId)
+	if err != nil {
+		return storageError("get history", err)
+	}
+
+	if historyWindow(histories, app.historyTurns, app.historyTokens) == 0 {
+		return nil
+	}
+
+	start := historyWindow(histories, app.historyTurns/2, app.historyTokens/2)
+	if start == 0 {
+		return nil
+	}
+
+	previous := ""
+	if s, err := app.store.GetSummary(ctx, userId, sessionId); err == nil {
+		previous = s.Text
+	} else if !errors.Is(err, store.ErrNotFound) {
+		return storageError("get summary", err)
+	}
+
+	text, err := app.larn.Summarize(ctx, previous, larnHistory(histories[:start]))
+	if err != nil {
+		return err
+	}
+
+	if err := app.store.CompactHistory(ctx, userId, sessionId, start, models.Summary{
+		Text:      text,
+		UpdatedAt: time.Now(),
+	}); err != nil {
+		return storageError("compact history", err)
+	}
+
+	log.Printf("Summarized %d turns of %s\n", start, userId)
+
+	return nil
+}
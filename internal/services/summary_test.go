@@ -0,0 +1,46 @@
+package services
+
+import (
+	"larn-line/internal/models"
+	"testing"
+)
+
+func TestHistoryWindow(t *testing.T) {
+	turns := func(n int) []models.History {
+		histories := make([]models.History, 0, 2*n)
+		for range n {
+			histories = append(histories,
+				models.History{From: "user", Message: "ถาม"},
+				models.History{From: "model", Message: "ตอบ"},
+			)
+		}
+		return histories
+	}
+
+	tests := []struct {
+		name      string
+		histories []models.History
+		turns     int
+		tokens    int
+		want      int
+	}{
+		{"empty", nil, 20, 1000, 0},
+		{"all fit", turns(3), 20, 1000, 0},
+		{"turns are pairs", turns(30), 20, 1000, 20},
+		{"token budget", turns(10), 20, 4, 16},
+		{"answer left without its question", turns(2)[1:], 20, 1000, 1},
+		{
+			"operator replies belong to the question",
+			append(turns(1), models.History{From: "operator", Message: "ตอบ"}, models.History{From: "model", Message: "ตอบ"}),
+			1, 1000, 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := historyWindow(tt.histories, tt.turns, tt.tokens); got != tt.want {
+				t.Errorf("historyWindow() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
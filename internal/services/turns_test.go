@@ -0,0 +1,315 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"larn-line/internal/constants"
+	"larn-line/internal/models"
+	"larn-line/internal/rules"
+	"larn-line/internal/store"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/line/line-bot-sdk-go/v8/linebot/messaging_api"
+)
+
+// recordingLarn answers every message with the same classification and
+// records the order questions were asked in and the history each one came
+// with.
+type recordingLarn struct {
+	mu        sync.Mutex
+	asked     []string
+	histories [][]models.History
+}
+
+func (l *recordingLarn) Message(ctx context.Context, message string, summary string, history []models.History) (*models.Message, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.asked = append(l.asked, message)
+	l.histories = append(l.histories, history)
+
+	return &models.Message{Classification: "general", Response: "answer to " + message}, nil
+}
+
+func (l *recordingLarn) StreamMessage(ctx context.Context, message string, summary string, history []models.History, onText func(text string)) (*models.Message, error) {
+	return l.Message(ctx, message, summary, history)
+}
+
+func (l *recordingLarn) Recommend(ctx context.Context, message string) ([]string, error) {
+	return nil, nil
+}
+
+func (l *recordingLarn) CheckImage(ctx context.Context, image []byte, contentType string) (*models.Message, error) {
+	return nil, fmt.Errorf("not supported")
+}
+
+func (l *recordingLarn) Summarize(ctx context.Context, summary string, history []models.History) (string, error) {
+	return summary, nil
+}
+
+func newTestService(t *testing.T, larn LarnClient, s store.Store) *LineService {
+	t.Helper()
+
+	line := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte("{}"))
+	}))
+	t.Cleanup(line.Close)
+
+	app, err := NewLineService(Config{
+		ChannelToken:     "test",
+		Larn:             larn,
+		Store:            s,
+		HistoryTurns:     1000,
+		HistoryTokens:    1000000,
+		AgentSwitch:      NewSession,
+		RecommendTimeout: time.Second,
+		Workers:          1,
+		QueueSize:        1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(app.Close)
+
+	app.bot, err = messaging_api.NewMessagingApiAPI("test", messaging_api.WithEndpoint(line.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return app
+}
+
+func TestConcurrentTurnsAreSavedInOrder(t *testing.T) {
+	const userId, turns = "U1", 40
+
+	larn := &recordingLarn{}
+	s := store.NewMemoryStore()
+	app := newTestService(t, larn, s)
+
+	var wg sync.WaitGroup
+	for i := range turns {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := app.handleLarnMessage(userId, fmt.Sprintf("question %d", i), "token"); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// The last turn is saved before its lock is released.
+	app.turns.lock(userId)()
+
+	ctx := context.Background()
+	user, err := s.GetUser(ctx, userId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.SessionId == "" {
+		t.Fatal("turns were not saved to a session")
+	}
+
+	sessions, err := s.ListSessions(ctx, userId, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 1 || sessions[0].Id != user.SessionId {
+		t.Fatalf("got sessions %+v, want only %s", sessions, user.SessionId)
+	}
+
+	histories, err := s.GetHistory(ctx, userId, user.SessionId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(histories) != 2*turns {
+		t.Fatalf("got %d history entries, want %d", len(histories), 2*turns)
+	}
+
+	// Turns are stored in the order Larn answered them, each question
+	// followed by its answer, and every question saw all turns before it.
+	for i, question := range larn.asked {
+		if got := histories[2*i]; got.From != "user" || got.Message != question {
+			t.Errorf("entry %d is %+v, want question %q", 2*i, got, question)
+		}
+		if got := histories[2*i+1]; got.From != "model" || got.Message != "answer to "+question {
+			t.Errorf("entry %d is %+v, want the answer to %q", 2*i+1, got, question)
+		}
+		if got := len(larn.histories[i]); got != 2*i {
+			t.Errorf("question %d was asked with %d history entries, want %d", i, got, 2*i)
+		}
+	}
+
+	seen := make(map[string]bool)
+	for _, question := range larn.asked {
+		if seen[question] {
+			t.Errorf("%q was asked twice", question)
+		}
+		seen[question] = true
+	}
+	if len(seen) != turns {
+		t.Errorf("got %d questions, want %d", len(seen), turns)
+	}
+}
+
+func TestLegacyTurnsMoveToSessions(t *testing.T) {
+	tests := []struct {
+		name  string
+		agent string
+		// sessions is how many sessions the user ends up with.
+		sessions int
+	}{
+		{"same agent", "general", 1},
+		{"other agent", "scam", 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			const userId = "U1"
+
+			ctx := context.Background()
+			s := store.NewMemoryStore()
+			if err := s.UpdateUser(ctx, userId, func(user *models.User) error {
+				user.CurrentAgent = tt.agent
+				return nil
+			}); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.AddHistory(ctx, userId, "",
+				models.History{From: "user", Message: "old question"},
+				models.History{From: "model", Message: "old answer"},
+			); err != nil {
+				t.Fatal(err)
+			}
+
+			larn := &recordingLarn{}
+			app := newTestService(t, larn, s)
+			if err := app.handleLarnMessage(userId, "new question", "token"); err != nil {
+				t.Fatal(err)
+			}
+			app.turns.lock(userId)()
+
+			if got := len(larn.histories[0]); got != 2 {
+				t.Errorf("asked with %d history entries, want the 2 old ones", got)
+			}
+
+			legacy, err := s.GetHistory(ctx, userId, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(legacy) != 0 {
+				t.Errorf("%d entries were left outside sessions", len(legacy))
+			}
+
+			sessions, err := s.ListSessions(ctx, userId, 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(sessions) != tt.sessions {
+				t.Fatalf("got %d sessions, want %d", len(sessions), tt.sessions)
+			}
+
+			var saved int
+			for _, session := range sessions {
+				histories, err := s.GetHistory(ctx, userId, session.Id)
+				if err != nil {
+					t.Fatal(err)
+				}
+				saved += len(histories)
+			}
+			if saved != 4 {
+				t.Errorf("got %d entries across sessions, want 4", saved)
+			}
+
+			// The earlier conversation is offered to resume.
+			if tt.sessions == 2 && (sessions[1].Title != constants.EARLIER_TOPIC_TITLE || sessions[1].EndedAt == nil) {
+				t.Errorf("got %+v, want the earlier conversation ended", sessions[1])
+			}
+		})
+	}
+}
+
+func TestOldSessionsArePruned(t *testing.T) {
+	const userId = "U1"
+
+	ctx := context.Background()
+	s := store.NewMemoryStore()
+	app := newTestService(t, &recordingLarn{}, s)
+	app.keepSessions = 2
+
+	sessionIds := make([]string, 0)
+	for i := range 4 {
+		if err := s.UpdateUser(ctx, userId, func(user *models.User) error {
+			user.NewTopic = true
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if err := app.handleLarnMessage(userId, fmt.Sprintf("question %d", i), "token"); err != nil {
+			t.Fatal(err)
+		}
+		app.turns.lock(userId)()
+
+		sessionId, err := app.currentSession(ctx, userId)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sessionIds = append(sessionIds, sessionId)
+	}
+
+	// Pruning follows the turn after its lock is released.
+	var sessions []models.Session
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(10 * time.Millisecond) {
+		var err error
+		if sessions, err = s.ListSessions(ctx, userId, 10); err != nil {
+			t.Fatal(err)
+		}
+		if len(sessions) <= 2 || time.Now().After(deadline) {
+			break
+		}
+	}
+	if len(sessions) != 2 || sessions[0].Id != sessionIds[3] || sessions[1].Id != sessionIds[2] {
+		t.Fatalf("got sessions %+v, want the latest two", sessions)
+	}
+
+	histories, err := s.GetHistory(ctx, userId, sessionIds[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(histories) != 0 {
+		t.Errorf("the oldest session kept %d history entries", len(histories))
+	}
+}
+
+func TestTypedPlaceholderIsNotAnImage(t *testing.T) {
+	app := newTestService(t, &recordingLarn{}, store.NewMemoryStore())
+
+	var err error
+	app.rules, err = rules.NewEngine([]rules.Rule{{Name: "all", Classifications: []string{"general"}, Action: "record"}}, store.NewMemoryStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	kinds := make(chan string, 1)
+	app.rules.Register("record", func(ctx context.Context, rule *rules.Rule, event rules.Event) ([]string, error) {
+		kinds <- event.Kind
+		return nil, nil
+	})
+
+	if err := app.handleLarnMessage("U1", imageHistoryText, "token"); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case kind := <-kinds:
+		if kind != "text" {
+			t.Errorf("got kind %q, want text", kind)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no event was evaluated")
+	}
+}
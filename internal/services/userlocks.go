@@ -0,0 +1,75 @@
+package services
+
+import "sync"
+
+// userLocks serializes reading and writing one user's conversation. Turns
+// are saved after the reply goes out, so without it the next message could
+// read the history before the previous turn is in it.
+type userLocks struct {
+	mu    sync.Mutex
+	locks map[string]*userLock
+}
+
+type userLock struct {
+	sync.Mutex
+	// holders counts the goroutines holding or waiting for the lock, so it
+	// can be dropped from the map once nobody needs it.
+	holders int
+}
+
+func newUserLocks() *userLocks {
+	return &userLocks{locks: make(map[string]*userLock)}
+}
+
+// tryLock takes the user's lock unless it is held, in which case ok is
+// false and there is nothing to release.
+func (l *userLocks) tryLock(userId string) (release func(), ok bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	lock := l.entry(userId)
+	if !lock.TryLock() {
+		return nil, false
+	}
+	lock.holders++
+
+	return l.release(userId, lock), true
+}
+
+// lock waits for the user's lock and returns the function releasing it.
+func (l *userLocks) lock(userId string) func() {
+	l.mu.Lock()
+	lock := l.entry(userId)
+	lock.holders++
+	l.mu.Unlock()
+
+	lock.Lock()
+
+	return l.release(userId, lock)
+}
+
+// entry returns the user's lock, adding it to the map. l.mu must be held.
+func (l *userLocks) entry(userId string) *userLock {
+	lock, ok := l.locks[userId]
+	if !ok {
+		lock = &userLock{}
+		l.locks[userId] = lock
+	}
+	return lock
+}
+
+func (l *userLocks) release(userId string, lock *userLock) func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			lock.Unlock()
+
+			l.mu.Lock()
+			lock.holders--
+			if lock.holders == 0 {
+				delete(l.locks, userId)
+			}
+			l.mu.Unlock()
+		})
+	}
+}
@@ -0,0 +1,46 @@
+package services
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestTryLockTakesTheLockOnce(t *testing.T) {
+	locks := newUserLocks()
+
+	var taken atomic.Int32
+	var tried, wg sync.WaitGroup
+	tried.Add(50)
+	for range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			release, ok := locks.tryLock("U1")
+			tried.Done()
+			if !ok {
+				return
+			}
+			taken.Add(1)
+
+			// Held until every goroutine has tried.
+			tried.Wait()
+			release()
+		}()
+	}
+	wg.Wait()
+
+	if got := taken.Load(); got != 1 {
+		t.Errorf("the lock was taken %d times, want once", got)
+	}
+	if len(locks.locks) != 0 {
+		t.Errorf("%d locks were left in the map", len(locks.locks))
+	}
+
+	if release, ok := locks.tryLock("U1"); !ok {
+		t.Error("the lock was not free after its release")
+	} else {
+		release()
+	}
+}
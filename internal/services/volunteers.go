@@ -0,0 +1,231 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Volunteer is a real grandchild elders can be handed over to.
+type Volunteer struct {
+	Id   string `json:"id"`
+	Name string `json:"name"`
+	// Url opens the volunteer's LINE account, usually https://line.me/ti/p/...
+	Url   string         `json:"url"`
+	Hours []WorkingHours `json:"hours"`
+}
+
+// WorkingHours is a shift such as {"days": ["sat", "sun"], "start": "09:00",
+// "end": "17:00"}. No days means every day, and a shift may end past
+// midnight.
+type WorkingHours struct {
+	Days  []string `json:"days"`
+	Start string   `json:"start"`
+	End   string   `json:"end"`
+}
+
+func parseClock(clock string) (int, error) {
+	t, err := time.Parse("15:04", clock)
+	if err != nil {
+		return 0, err
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+func (h WorkingHours) validate() error {
+	if _, err := parseClock(h.Start); err != nil {
+		return fmt.Errorf("start: %w", err)
+	}
+	if _, err := parseClock(h.End); err != nil {
+		return fmt.Errorf("end: %w", err)
+	}
+	for _, day := range h.Days {
+		if _, ok := weekdays[strings.ToLower(day)]; !ok {
+			return fmt.Errorf("unknown day %q", day)
+		}
+	}
+	return nil
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+func (h WorkingHours) onDay(day time.Weekday) bool {
+	if len(h.Days) == 0 {
+		return true
+	}
+	for _, d := range h.Days {
+		if weekdays[strings.ToLower(d)] == day {
+			return true
+		}
+	}
+	return false
+}
+
+func (h WorkingHours) contains(now time.Time) bool {
+	start, _ := parseClock(h.Start)
+	end, _ := parseClock(h.End)
+	minute := now.Hour()*60 + now.Minute()
+
+	if start <= end {
+		return h.onDay(now.Weekday()) && minute >= start && minute < end
+	}
+
+	// The shift started yesterday when it is before end now.
+	if minute < end {
+		return h.onDay(now.AddDate(0, 0, -1).Weekday())
+	}
+	return h.onDay(now.Weekday()) && minute >= start
+}
+
+// OnDuty reports whether now falls in one of the volunteer's shifts. A
+// volunteer without hours is always on duty.
+func (v Volunteer) OnDuty(now time.Time) bool {
+	if len(v.Hours) == 0 {
+		return true
+	}
+	for _, h := range v.Hours {
+		if h.contains(now) {
+			return true
+		}
+	}
+	return false
+}
+
+type PickStrategy string
+
+const (
+	RoundRobin PickStrategy = "round_robin"
+	// LeastBusy picks whoever accepted the fewest handoffs in the last
+	// busyWindow.
+	LeastBusy PickStrategy = "least_busy"
+)
+
+const busyWindow = time.Hour
+
+// Roster picks which volunteer an elder is handed over to.
+type Roster struct {
+	volunteers []Volunteer
+	strategy   PickStrategy
+	location   *time.Location
+
+	mu       sync.Mutex
+	next     int
+	accepted map[string][]time.Time
+}
+
+func NewRoster(volunteers []Volunteer, strategy PickStrategy, location *time.Location) (*Roster, error) {
+	switch strategy {
+	case RoundRobin, LeastBusy:
+	default:
+		return nil, fmt.Errorf("unknown roster strategy %q", strategy)
+	}
+
+	for _, v := range volunteers {
+		if v.Id == "" || v.Url == "" {
+			return nil, fmt.Errorf("volunteer %q needs an id and a url", v.Name)
+		}
+		for _, h := range v.Hours {
+			if err := h.validate(); err != nil {
+				return nil, fmt.Errorf("volunteer %s: %w", v.Id, err)
+			}
+		}
+	}
+
+	return &Roster{
+		volunteers: volunteers,
+		strategy:   strategy,
+		location:   location,
+		accepted:   make(map[string][]time.Time),
+	}, nil
+}
+
+// LoadRoster reads volunteers from a JSON array in path.
+func LoadRoster(path string, strategy PickStrategy, location *time.Location) (*Roster, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var volunteers []Volunteer
+	if err := json.Unmarshal(data, &volunteers); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+
+	return NewRoster(volunteers, strategy, location)
+}
+
+func (r *Roster) Get(id string) (Volunteer, bool) {
+	for _, v := range r.volunteers {
+		if v.Id == id {
+			return v, true
+		}
+	}
+	return Volunteer{}, false
+}
+
+// Pick returns a volunteer on duty at now, or false when nobody is.
+func (r *Roster) Pick(now time.Time) (Volunteer, bool) {
+	now = now.In(r.location)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	best := -1
+	bestLoad := 0
+	for i := range r.volunteers {
+		// Start after the last pick so that ties go round the roster.
+		n := (r.next + i) % len(r.volunteers)
+		v := r.volunteers[n]
+		if !v.OnDuty(now) {
+			continue
+		}
+
+		if r.strategy == RoundRobin {
+			best = n
+			break
+		}
+
+		load := r.load(v.Id, now)
+		if best == -1 || load < bestLoad {
+			best, bestLoad = n, load
+		}
+	}
+
+	if best == -1 {
+		return Volunteer{}, false
+	}
+
+	r.next = best + 1
+	return r.volunteers[best], true
+}
+
+// Accepted counts a handoff to the volunteer towards their load.
+func (r *Roster) Accepted(id string, now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.accepted[id] = append(r.accepted[id], now)
+}
+
+func (r *Roster) load(id string, now time.Time) int {
+	times := r.accepted[id]
+	recent := times[:0]
+	for _, t := range times {
+		if now.Sub(t) < busyWindow {
+			recent = append(recent, t)
+		}
+	}
+	r.accepted[id] = recent
+	return len(recent)
+}
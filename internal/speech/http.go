@@ -0,0 +1,76 @@
+package speech
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+type HTTPConfig struct {
+	URL      string
+	APIKey   string
+	Language string
+	Timeout  time.Duration
+}
+
+type httpSpeechToText struct {
+	config HTTPConfig
+	client *http.Client
+}
+
+// NewHTTPSpeechToText posts the raw audio to config.URL and expects a JSON
+// body of the form {"text": "..."} back.
+func NewHTTPSpeechToText(config HTTPConfig) SpeechToText {
+	return &httpSpeechToText{
+		config: config,
+		client: &http.Client{
+			Timeout: config.Timeout,
+		},
+	}
+}
+
+func (s *httpSpeechToText) Transcribe(ctx context.Context, audio []byte, contentType string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", s.config.URL, bytes.NewReader(audio))
+	if err != nil {
+		return "", err
+	}
+
+	req.Header.Set("Content-Type", contentType)
+	if s.config.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+s.config.APIKey)
+	}
+
+	q := req.URL.Query()
+	q.Set("language", s.config.Language)
+	req.URL.RawQuery = q.Encode()
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return "", err
+	}
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return "", fmt.Errorf("speech to text returned %d: %s", res.StatusCode, bytes.TrimSpace(body))
+	}
+
+	var response struct {
+		Text string `json:"text"`
+	}
+
+	if err := json.Unmarshal(body, &response); err != nil {
+		return "", err
+	}
+
+	return response.Text, nil
+}
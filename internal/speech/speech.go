@@ -0,0 +1,18 @@
+package speech
+
+import "context"
+
+// SpeechToText turns a recording of a user's voice into Thai text.
+type SpeechToText interface {
+	Transcribe(ctx context.Context, audio []byte, contentType string) (string, error)
+}
+
+// StaticSpeechToText always hears Text. It stands in for a real service in
+// tests and local development.
+type StaticSpeechToText struct {
+	Text string
+}
+
+func (s StaticSpeechToText) Transcribe(ctx context.Context, audio []byte, contentType string) (string, error) {
+	return s.Text, nil
+}
@@ -0,0 +1,80 @@
+package speech
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// TextToSpeech reads Thai text aloud.
+type TextToSpeech interface {
+	// Synthesize renders text as m4a audio and reports how long it plays.
+	Synthesize(ctx context.Context, text string) ([]byte, time.Duration, error)
+}
+
+type httpTextToSpeech struct {
+	config HTTPConfig
+	client *http.Client
+}
+
+// NewHTTPTextToSpeech posts {"text", "language"} to config.URL and expects
+// {"audio": "<base64 m4a>", "durationMs": n} back.
+func NewHTTPTextToSpeech(config HTTPConfig) TextToSpeech {
+	return &httpTextToSpeech{
+		config: config,
+		client: &http.Client{
+			Timeout: config.Timeout,
+		},
+	}
+}
+
+func (s *httpTextToSpeech) Synthesize(ctx context.Context, text string) ([]byte, time.Duration, error) {
+	payload, err := json.Marshal(map[string]string{
+		"text":     text,
+		"language": s.config.Language,
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", s.config.URL, bytes.NewReader(payload))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	if s.config.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+s.config.APIKey)
+	}
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return nil, 0, fmt.Errorf("text to speech returned %d: %s", res.StatusCode, bytes.TrimSpace(body))
+	}
+
+	var response struct {
+		Audio      []byte `json:"audio"`
+		DurationMs int64  `json:"durationMs"`
+	}
+
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, 0, err
+	}
+
+	return response.Audio, time.Duration(response.DurationMs) * time.Millisecond, nil
+}
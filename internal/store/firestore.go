@@ -0,0 +1,782 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"larn-line/internal/models"
+	"larn-line/internal/utils"
+	"net/url"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type firestoreStore struct {
+	firestore *firestore.Client
+}
+
+// NewFirestoreStore keeps users in the users collection with their history
+// in the messages subcollection and their pending answer in pending/answer.
+func NewFirestoreStore(client *firestore.Client) Store {
+	return &firestoreStore{
+		firestore: client,
+	}
+}
+
+func (s *firestoreStore) userDoc(userId string) *firestore.DocumentRef {
+	return s.firestore.Collection("users").Doc(userId)
+}
+
+func (s *firestoreStore) GetUser(ctx context.Context, userId string) (*models.User, error) {
+	snap, err := s.userDoc(userId).Get(ctx)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	var user models.User
+	if err := snap.DataTo(&user); err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+func (s *firestoreStore) CreateUserIfNotExist(ctx context.Context, userId string) error {
+	_, err := s.userDoc(userId).Create(ctx, models.User{})
+	if err != nil && status.Code(err) != codes.AlreadyExists {
+		return err
+	}
+	return nil
+}
+
+func (s *firestoreStore) UpdateUser(ctx context.Context, userId string, update func(user *models.User) error) error {
+	userDoc := s.userDoc(userId)
+
+	return s.firestore.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		var user models.User
+
+		snap, err := tx.Get(userDoc)
+		if err != nil && status.Code(err) != codes.NotFound {
+			return err
+		}
+
+		if snap != nil && snap.Exists() {
+			if err := snap.DataTo(&user); err != nil {
+				return err
+			}
+		}
+
+		if err := update(&user); err != nil {
+			return err
+		}
+
+		return tx.Set(userDoc, user)
+	})
+}
+
+func (s *firestoreStore) DeleteUser(ctx context.Context, userId string) error {
+	if err := s.ClearHistory(ctx, userId); err != nil {
+		return err
+	}
+
+	if err := s.DeletePendingAnswer(ctx, userId); err != nil {
+		return err
+	}
+
+	if err := utils.DeleteCollection(s.firestore, fmt.Sprintf("users/%s/activities", userId)); err != nil {
+		return err
+	}
+
+	if err := utils.DeleteCollection(s.firestore, fmt.Sprintf("users/%s/cooldowns", userId)); err != nil {
+		return err
+	}
+
+	// Pending messages used to be kept one per document in tmp_messages.
+	if err := utils.DeleteCollection(s.firestore, fmt.Sprintf("users/%s/tmp_messages", userId)); err != nil {
+		return err
+	}
+
+	_, err := s.userDoc(userId).Delete(ctx)
+	return err
+}
+
+func (s *firestoreStore) ListUsersByMode(ctx context.Context, mode string) ([]string, error) {
+	iter := s.firestore.Collection("users").Where("mode", "==", mode).Select().Documents(ctx)
+
+	userIds := make([]string, 0)
+
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		userIds = append(userIds, doc.Ref.ID)
+	}
+
+	return userIds, nil
+}
+
+// ListUsersWithCaregivers relies on caregivers being left out of the
+// document when empty, so any value found is a non-empty list.
+func (s *firestoreStore) ListUsersWithCaregivers(ctx context.Context) ([]string, error) {
+	iter := s.firestore.Collection("users").Where("caregivers", "!=", []string{}).Select().Documents(ctx)
+
+	userIds := make([]string, 0)
+
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		userIds = append(userIds, doc.Ref.ID)
+	}
+
+	return userIds, nil
+}
+
+// sessionDoc holds a session's turns and summary. Turns from before
+// sessions were kept directly under the user.
+func (s *firestoreStore) sessionDoc(userId string, sessionId string) *firestore.DocumentRef {
+	if sessionId == "" {
+		return s.userDoc(userId)
+	}
+	return s.userDoc(userId).Collection("sessions").Doc(sessionId)
+}
+
+func (s *firestoreStore) summaryDoc(userId string, sessionId string) *firestore.DocumentRef {
+	return s.sessionDoc(userId, sessionId).Collection("summary").Doc("current")
+}
+
+func (s *firestoreStore) GetHistory(ctx context.Context, userId string, sessionId string) ([]models.History, error) {
+	iter := s.sessionDoc(userId, sessionId).Collection("messages").OrderBy("timestamp", firestore.Asc).Documents(ctx)
+
+	histories := make([]models.History, 0)
+
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var history models.History
+		if err := doc.DataTo(&history); err != nil {
+			return nil, err
+		}
+
+		histories = append(histories, history)
+	}
+
+	return histories, nil
+}
+
+// AddHistory stamps turns a microsecond apart, so that a question and its
+// answer saved together keep their order when sorted by time.
+func (s *firestoreStore) AddHistory(ctx context.Context, userId string, sessionId string, histories ...models.History) error {
+	messages := s.sessionDoc(userId, sessionId).Collection("messages")
+
+	now := time.Now()
+	for i, history := range histories {
+		if history.Timestamp.IsZero() {
+			history.Timestamp = now.Add(time.Duration(i) * time.Microsecond)
+		}
+		if _, _, err := messages.Add(ctx, history); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SaveTurn writes the user, the session changes and the turns in one
+// transaction, so a turn is never saved against a session the user has
+// already left.
+func (s *firestoreStore) SaveTurn(ctx context.Context, userId string, update func(user *models.User) (*models.Turn, error)) error {
+	userDoc := s.userDoc(userId)
+
+	return s.firestore.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		var user models.User
+
+		snap, err := tx.Get(userDoc)
+		if err != nil && status.Code(err) != codes.NotFound {
+			return err
+		}
+
+		if snap != nil && snap.Exists() {
+			if err := snap.DataTo(&user); err != nil {
+				return err
+			}
+		}
+
+		left := user.SessionId
+
+		turn, err := update(&user)
+		if err != nil {
+			return err
+		}
+
+		// A transaction reads everything before it writes.
+		var legacy legacyTurns
+		if turn.Legacy != nil {
+			if legacy, err = s.readLegacy(tx, userId); err != nil {
+				return err
+			}
+		}
+
+		if err := tx.Set(userDoc, user); err != nil {
+			return err
+		}
+
+		if turn.Session != nil {
+			if left != "" && left != user.SessionId {
+				if err := tx.Set(s.sessionDoc(userId, left), map[string]any{
+					"endedAt": turn.Session.StartedAt,
+				}, firestore.MergeAll); err != nil {
+					return err
+				}
+			}
+
+			if err := tx.Set(s.sessionDoc(userId, turn.Session.Id), *turn.Session); err != nil {
+				return err
+			}
+		}
+
+		if turn.Legacy != nil {
+			if err := s.moveLegacy(tx, userId, turn, legacy); err != nil {
+				return err
+			}
+		}
+
+		messages := s.sessionDoc(userId, user.SessionId).Collection("messages")
+
+		now := time.Now()
+		for i, history := range turn.Histories {
+			if history.Timestamp.IsZero() {
+				history.Timestamp = now.Add(time.Duration(i) * time.Microsecond)
+			}
+			if err := tx.Create(messages.NewDoc(), history); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// legacyTurns are the turns saved before sessions and their summary.
+type legacyTurns struct {
+	messages []*firestore.DocumentSnapshot
+	summary  *firestore.DocumentSnapshot
+}
+
+func (s *firestoreStore) readLegacy(tx *firestore.Transaction, userId string) (legacyTurns, error) {
+	var legacy legacyTurns
+
+	messages, err := tx.Documents(s.sessionDoc(userId, "").Collection("messages").OrderBy("timestamp", firestore.Asc)).GetAll()
+	if err != nil {
+		return legacy, err
+	}
+	legacy.messages = messages
+
+	summary, err := tx.Get(s.summaryDoc(userId, ""))
+	if err != nil && status.Code(err) != codes.NotFound {
+		return legacy, err
+	}
+	if summary != nil && summary.Exists() {
+		legacy.summary = summary
+	}
+
+	return legacy, nil
+}
+
+// moveLegacy copies the legacy turns to the turn's Legacy session and
+// deletes them from under the user.
+func (s *firestoreStore) moveLegacy(tx *firestore.Transaction, userId string, turn *models.Turn, legacy legacyTurns) error {
+	if len(legacy.messages) == 0 && legacy.summary == nil {
+		return nil
+	}
+
+	session := *turn.Legacy
+	if turn.Session == nil || turn.Session.Id != session.Id {
+		if len(legacy.messages) > 0 {
+			session.StartedAt, _ = legacy.messages[0].Data()["timestamp"].(time.Time)
+		} else {
+			session.StartedAt, _ = legacy.summary.Data()["updatedAt"].(time.Time)
+		}
+		if err := tx.Set(s.sessionDoc(userId, session.Id), session); err != nil {
+			return err
+		}
+	}
+
+	messages := s.sessionDoc(userId, session.Id).Collection("messages")
+	for _, doc := range legacy.messages {
+		if err := tx.Create(messages.Doc(doc.Ref.ID), doc.Data()); err != nil {
+			return err
+		}
+		if err := tx.Delete(doc.Ref); err != nil {
+			return err
+		}
+	}
+
+	if legacy.summary != nil {
+		if err := tx.Set(s.summaryDoc(userId, session.Id), legacy.summary.Data()); err != nil {
+			return err
+		}
+		if err := tx.Delete(legacy.summary.Ref); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *firestoreStore) ClearHistory(ctx context.Context, userId string) error {
+	sessions, err := s.userDoc(userId).Collection("sessions").Select().Documents(ctx).GetAll()
+	if err != nil {
+		return err
+	}
+
+	if err := s.deleteSession(ctx, userId, ""); err != nil {
+		return err
+	}
+	for _, doc := range sessions {
+		if err := s.deleteSession(ctx, userId, doc.Ref.ID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// deleteSession deletes a session's turns and summary, and the session
+// unless it is the empty one.
+func (s *firestoreStore) deleteSession(ctx context.Context, userId string, sessionId string) error {
+	messages := fmt.Sprintf("users/%s/messages", userId)
+	if sessionId != "" {
+		messages = fmt.Sprintf("users/%s/sessions/%s/messages", userId, sessionId)
+	}
+	if err := utils.DeleteCollection(s.firestore, messages); err != nil {
+		return err
+	}
+	if _, err := s.summaryDoc(userId, sessionId).Delete(ctx); err != nil {
+		return err
+	}
+	if sessionId != "" {
+		if _, err := s.sessionDoc(userId, sessionId).Delete(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CompactHistory saves the summary and deletes the turns in one batch.
+func (s *firestoreStore) CompactHistory(ctx context.Context, userId string, sessionId string, n int, summary models.Summary) error {
+	docs, err := s.sessionDoc(userId, sessionId).Collection("messages").
+		OrderBy("timestamp", firestore.Asc).
+		Limit(n).
+		Documents(ctx).
+		GetAll()
+	if err != nil {
+		return err
+	}
+
+	batch := s.firestore.Batch()
+	batch.Set(s.summaryDoc(userId, sessionId), summary)
+	for _, doc := range docs {
+		batch.Delete(doc.Ref)
+	}
+
+	_, err = batch.Commit(ctx)
+	return err
+}
+
+func (s *firestoreStore) GetSummary(ctx context.Context, userId string, sessionId string) (*models.Summary, error) {
+	snap, err := s.summaryDoc(userId, sessionId).Get(ctx)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	var summary models.Summary
+	if err := snap.DataTo(&summary); err != nil {
+		return nil, err
+	}
+
+	return &summary, nil
+}
+
+func (s *firestoreStore) SaveSession(ctx context.Context, userId string, session models.Session) error {
+	_, err := s.sessionDoc(userId, session.Id).Set(ctx, session)
+	return err
+}
+
+func (s *firestoreStore) GetSession(ctx context.Context, userId string, sessionId string) (*models.Session, error) {
+	if sessionId == "" {
+		return nil, ErrNotFound
+	}
+
+	snap, err := s.sessionDoc(userId, sessionId).Get(ctx)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	var session models.Session
+	if err := snap.DataTo(&session); err != nil {
+		return nil, err
+	}
+	session.Id = snap.Ref.ID
+
+	return &session, nil
+}
+
+func (s *firestoreStore) ListSessions(ctx context.Context, userId string, limit int) ([]models.Session, error) {
+	docs, err := s.userDoc(userId).Collection("sessions").
+		OrderBy("startedAt", firestore.Desc).
+		Limit(limit).
+		Documents(ctx).
+		GetAll()
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := make([]models.Session, 0, len(docs))
+	for _, doc := range docs {
+		var session models.Session
+		if err := doc.DataTo(&session); err != nil {
+			return nil, err
+		}
+		session.Id = doc.Ref.ID
+		sessions = append(sessions, session)
+	}
+
+	return sessions, nil
+}
+
+// PruneSessions is not a transaction. A session resumed while it runs may
+// still be deleted, leaving the user to start a new one.
+func (s *firestoreStore) PruneSessions(ctx context.Context, userId string, keep int) error {
+	user, err := s.GetUser(ctx, userId)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	docs, err := s.userDoc(userId).Collection("sessions").
+		OrderBy("startedAt", firestore.Desc).
+		Offset(keep).
+		Select().
+		Documents(ctx).
+		GetAll()
+	if err != nil {
+		return err
+	}
+
+	for _, doc := range docs {
+		if doc.Ref.ID == user.SessionId {
+			continue
+		}
+		if err := s.deleteSession(ctx, userId, doc.Ref.ID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+type firestorePendingAnswer struct {
+	AnswerId string        `firestore:"answerId"`
+	Items    []pendingItem `firestore:"items"`
+	NextPage int           `firestore:"nextPage"`
+	ExpireAt time.Time     `firestore:"expireAt"`
+}
+
+// pendingDoc holds the user's pending answer in a single document so it is
+// written and replaced in one operation. A TTL policy on expireAt can
+// remove expired answers.
+func (s *firestoreStore) pendingDoc(userId string) *firestore.DocumentRef {
+	return s.userDoc(userId).Collection("pending").Doc("answer")
+}
+
+func toFirestorePendingAnswer(answer *models.PendingAnswer) (*firestorePendingAnswer, error) {
+	items, err := encodePages(answer.Pages)
+	if err != nil {
+		return nil, err
+	}
+
+	return &firestorePendingAnswer{
+		AnswerId: answer.AnswerId,
+		Items:    items,
+		NextPage: answer.NextPage,
+		ExpireAt: answer.ExpireAt,
+	}, nil
+}
+
+func (s *firestoreStore) SavePendingAnswer(ctx context.Context, userId string, answer *models.PendingAnswer) error {
+	doc, err := toFirestorePendingAnswer(answer)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.pendingDoc(userId).Set(ctx, doc)
+	return err
+}
+
+func (s *firestoreStore) UpdatePendingAnswer(ctx context.Context, userId string, update func(answer *models.PendingAnswer) error) error {
+	pendingDoc := s.pendingDoc(userId)
+
+	return s.firestore.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		snap, err := tx.Get(pendingDoc)
+		if err != nil {
+			if status.Code(err) == codes.NotFound {
+				return ErrNotFound
+			}
+			return err
+		}
+
+		var stored firestorePendingAnswer
+		if err := snap.DataTo(&stored); err != nil {
+			return err
+		}
+
+		answer := models.PendingAnswer{
+			AnswerId: stored.AnswerId,
+			Pages:    decodePages(stored.Items),
+			NextPage: stored.NextPage,
+			ExpireAt: stored.ExpireAt,
+		}
+
+		if err := update(&answer); err != nil {
+			return err
+		}
+
+		doc, err := toFirestorePendingAnswer(&answer)
+		if err != nil {
+			return err
+		}
+
+		return tx.Set(pendingDoc, doc)
+	})
+}
+
+func (s *firestoreStore) DeletePendingAnswer(ctx context.Context, userId string) error {
+	_, err := s.pendingDoc(userId).Delete(ctx)
+	return err
+}
+
+type firestoreLinkCode struct {
+	UserId   string    `firestore:"userId"`
+	ExpireAt time.Time `firestore:"expireAt"`
+}
+
+func (s *firestoreStore) SaveLinkCode(ctx context.Context, code string, userId string, expireAt time.Time) error {
+	_, err := s.firestore.Collection("link_codes").Doc(code).Set(ctx, firestoreLinkCode{
+		UserId:   userId,
+		ExpireAt: expireAt,
+	})
+	return err
+}
+
+func (s *firestoreStore) TakeLinkCode(ctx context.Context, code string) (string, error) {
+	codeDoc := s.firestore.Collection("link_codes").Doc(code)
+
+	var linkCode firestoreLinkCode
+
+	err := s.firestore.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		snap, err := tx.Get(codeDoc)
+		if err != nil {
+			if status.Code(err) == codes.NotFound {
+				return ErrNotFound
+			}
+			return err
+		}
+
+		if err := snap.DataTo(&linkCode); err != nil {
+			return err
+		}
+
+		return tx.Delete(codeDoc)
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if time.Now().After(linkCode.ExpireAt) {
+		return "", ErrNotFound
+	}
+
+	return linkCode.UserId, nil
+}
+
+func (s *firestoreStore) AddActivity(ctx context.Context, userId string, activity models.Activity) error {
+	_, _, err := s.userDoc(userId).Collection("activities").Add(ctx, activity)
+	return err
+}
+
+func (s *firestoreStore) ListActivities(ctx context.Context, userId string, since time.Time) ([]models.Activity, error) {
+	iter := s.userDoc(userId).Collection("activities").
+		Where("timestamp", ">=", since).
+		OrderBy("timestamp", firestore.Asc).
+		Documents(ctx)
+
+	activities := make([]models.Activity, 0)
+
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var activity models.Activity
+		if err := doc.DataTo(&activity); err != nil {
+			return nil, err
+		}
+
+		activities = append(activities, activity)
+	}
+
+	return activities, nil
+}
+
+func (s *firestoreStore) DeleteActivities(ctx context.Context, userId string, before time.Time) error {
+	iter := s.userDoc(userId).Collection("activities").Where("timestamp", "<", before).Documents(ctx)
+	bulkwriter := s.firestore.BulkWriter(ctx)
+	defer bulkwriter.End()
+
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		if _, err := bulkwriter.Delete(doc.Ref); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *firestoreStore) AddAlert(ctx context.Context, alert models.Alert) error {
+	_, _, err := s.firestore.Collection("alerts").Add(ctx, alert)
+	return err
+}
+
+type firestoreCooldown struct {
+	StartedAt time.Time `firestore:"startedAt"`
+}
+
+// cooldownDoc is kept under the user; rule names may hold slashes, which
+// document ids cannot.
+func (s *firestoreStore) cooldownDoc(userId string, rule string) *firestore.DocumentRef {
+	return s.userDoc(userId).Collection("cooldowns").Doc(url.PathEscape(rule))
+}
+
+func (s *firestoreStore) ClaimCooldown(ctx context.Context, userId string, rule string, now time.Time, cooldown time.Duration) (bool, error) {
+	doc := s.cooldownDoc(userId, rule)
+	claimed := false
+
+	err := s.firestore.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		claimed = false
+
+		snap, err := tx.Get(doc)
+		if err != nil && status.Code(err) != codes.NotFound {
+			return err
+		}
+
+		if snap != nil && snap.Exists() {
+			var c firestoreCooldown
+			if err := snap.DataTo(&c); err != nil {
+				return err
+			}
+			if now.Sub(c.StartedAt) < cooldown {
+				return nil
+			}
+		}
+
+		claimed = true
+		return tx.Set(doc, firestoreCooldown{StartedAt: now})
+	})
+
+	return claimed, err
+}
+
+func (s *firestoreStore) ReleaseCooldown(ctx context.Context, userId string, rule string, now time.Time) error {
+	doc := s.cooldownDoc(userId, rule)
+
+	return s.firestore.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		snap, err := tx.Get(doc)
+		if status.Code(err) == codes.NotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		var c firestoreCooldown
+		if err := snap.DataTo(&c); err != nil {
+			return err
+		}
+		if !c.StartedAt.Equal(now) {
+			return nil
+		}
+
+		return tx.Delete(doc)
+	})
+}
+
+func (s *firestoreStore) ListAlerts(ctx context.Context, since time.Time) ([]models.Alert, error) {
+	iter := s.firestore.Collection("alerts").
+		Where("timestamp", ">=", since).
+		OrderBy("timestamp", firestore.Desc).
+		Documents(ctx)
+
+	alerts := make([]models.Alert, 0)
+
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var alert models.Alert
+		if err := doc.DataTo(&alert); err != nil {
+			return nil, err
+		}
+
+		alerts = append(alerts, alert)
+	}
+
+	return alerts, nil
+}
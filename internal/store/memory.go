@@ -0,0 +1,525 @@
+package store
+
+import (
+	"context"
+	"larn-line/internal/models"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+type memoryUser struct {
+	user          models.User
+	pendingAnswer *models.PendingAnswer
+	activities    []models.Activity
+	// Turns and summaries are kept by session id.
+	histories map[string][]models.History
+	summaries map[string]models.Summary
+	sessions  map[string]models.Session
+}
+
+type memoryLinkCode struct {
+	userId   string
+	expireAt time.Time
+}
+
+type memoryStore struct {
+	mu        sync.Mutex
+	users     map[string]*memoryUser
+	linkCodes map[string]memoryLinkCode
+	alerts    []models.Alert
+	// cooldowns holds when each user's rule cooldown started, by
+	// cooldownKey.
+	cooldowns map[string]time.Time
+}
+
+func cooldownKey(userId string, rule string) string {
+	return userId + "\x00" + rule
+}
+
+// NewMemoryStore keeps everything in process memory. It is meant for local
+// development and tests, and forgets all users on restart.
+func NewMemoryStore() Store {
+	return &memoryStore{
+		users:     make(map[string]*memoryUser),
+		linkCodes: make(map[string]memoryLinkCode),
+		cooldowns: make(map[string]time.Time),
+	}
+}
+
+// get returns the user's record, creating it when create is set. The caller
+// must hold s.mu.
+func (s *memoryStore) get(userId string, create bool) *memoryUser {
+	u, ok := s.users[userId]
+	if !ok && create {
+		u = &memoryUser{
+			histories: make(map[string][]models.History),
+			summaries: make(map[string]models.Summary),
+			sessions:  make(map[string]models.Session),
+		}
+		s.users[userId] = u
+	}
+	return u
+}
+
+func (s *memoryStore) GetUser(ctx context.Context, userId string) (*models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u := s.get(userId, false)
+	if u == nil {
+		return nil, ErrNotFound
+	}
+
+	user := cloneUser(u.user)
+	return &user, nil
+}
+
+func (s *memoryStore) CreateUserIfNotExist(ctx context.Context, userId string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.get(userId, true)
+	return nil
+}
+
+func (s *memoryStore) UpdateUser(ctx context.Context, userId string, update func(user *models.User) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u := s.get(userId, true)
+
+	user := cloneUser(u.user)
+	if err := update(&user); err != nil {
+		return err
+	}
+
+	u.user = user
+	return nil
+}
+
+func (s *memoryStore) DeleteUser(ctx context.Context, userId string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.users, userId)
+	for key := range s.cooldowns {
+		if strings.HasPrefix(key, userId+"\x00") {
+			delete(s.cooldowns, key)
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) ListUsersByMode(ctx context.Context, mode string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	userIds := make([]string, 0)
+	for userId, u := range s.users {
+		if u.user.Mode == mode {
+			userIds = append(userIds, userId)
+		}
+	}
+
+	return userIds, nil
+}
+
+func (s *memoryStore) ListUsersWithCaregivers(ctx context.Context) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	userIds := make([]string, 0)
+	for userId, u := range s.users {
+		if len(u.user.Caregivers) > 0 {
+			userIds = append(userIds, userId)
+		}
+	}
+
+	return userIds, nil
+}
+
+func (s *memoryStore) GetHistory(ctx context.Context, userId string, sessionId string) ([]models.History, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	histories := make([]models.History, 0)
+	if u := s.get(userId, false); u != nil {
+		histories = append(histories, u.histories[sessionId]...)
+	}
+
+	return histories, nil
+}
+
+func (s *memoryStore) AddHistory(ctx context.Context, userId string, sessionId string, histories ...models.History) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u := s.get(userId, true)
+	for _, history := range histories {
+		if history.Timestamp.IsZero() {
+			history.Timestamp = time.Now()
+		}
+		u.histories[sessionId] = append(u.histories[sessionId], history)
+	}
+
+	return nil
+}
+
+func (s *memoryStore) SaveTurn(ctx context.Context, userId string, update func(user *models.User) (*models.Turn, error)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u := s.get(userId, true)
+
+	user := cloneUser(u.user)
+	turn, err := update(&user)
+	if err != nil {
+		return err
+	}
+
+	if turn.Session != nil {
+		if left, ok := u.sessions[u.user.SessionId]; ok && left.Id != user.SessionId {
+			endedAt := turn.Session.StartedAt
+			left.EndedAt = &endedAt
+			u.sessions[left.Id] = left
+		}
+		u.sessions[turn.Session.Id] = *turn.Session
+	}
+
+	if turn.Legacy != nil {
+		u.adoptLegacy(turn)
+	}
+
+	now := time.Now()
+	for _, history := range turn.Histories {
+		if history.Timestamp.IsZero() {
+			history.Timestamp = now
+		}
+		u.histories[user.SessionId] = append(u.histories[user.SessionId], history)
+	}
+
+	u.user = user
+	return nil
+}
+
+// adoptLegacy moves the turns from before sessions, and their summary, to
+// the turn's Legacy session.
+func (u *memoryUser) adoptLegacy(turn *models.Turn) {
+	session := *turn.Legacy
+	histories := u.histories[""]
+	summary, summarized := u.summaries[""]
+	if len(histories) == 0 && !summarized {
+		return
+	}
+
+	if turn.Session == nil || turn.Session.Id != session.Id {
+		if len(histories) > 0 {
+			session.StartedAt = histories[0].Timestamp
+		} else {
+			session.StartedAt = summary.UpdatedAt
+		}
+		u.sessions[session.Id] = session
+	}
+
+	u.histories[session.Id] = append(histories, u.histories[session.Id]...)
+	delete(u.histories, "")
+	if summarized {
+		u.summaries[session.Id] = summary
+		delete(u.summaries, "")
+	}
+}
+
+func (s *memoryStore) ClearHistory(ctx context.Context, userId string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if u := s.get(userId, false); u != nil {
+		clear(u.histories)
+		clear(u.summaries)
+		clear(u.sessions)
+	}
+
+	return nil
+}
+
+func (s *memoryStore) CompactHistory(ctx context.Context, userId string, sessionId string, n int, summary models.Summary) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u := s.get(userId, true)
+	histories := u.histories[sessionId]
+	u.histories[sessionId] = append([]models.History(nil), histories[min(n, len(histories)):]...)
+	u.summaries[sessionId] = summary
+
+	return nil
+}
+
+func (s *memoryStore) GetSummary(ctx context.Context, userId string, sessionId string) (*models.Summary, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u := s.get(userId, false)
+	if u == nil {
+		return nil, ErrNotFound
+	}
+
+	summary, ok := u.summaries[sessionId]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return &summary, nil
+}
+
+func (s *memoryStore) SaveSession(ctx context.Context, userId string, session models.Session) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if session.EndedAt != nil {
+		endedAt := *session.EndedAt
+		session.EndedAt = &endedAt
+	}
+	s.get(userId, true).sessions[session.Id] = session
+
+	return nil
+}
+
+func (s *memoryStore) GetSession(ctx context.Context, userId string, sessionId string) (*models.Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u := s.get(userId, false)
+	if u == nil {
+		return nil, ErrNotFound
+	}
+
+	session, ok := u.sessions[sessionId]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return &session, nil
+}
+
+func (s *memoryStore) ListSessions(ctx context.Context, userId string, limit int) ([]models.Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sessions := make([]models.Session, 0)
+	if u := s.get(userId, false); u != nil {
+		for _, session := range u.sessions {
+			sessions = append(sessions, session)
+		}
+	}
+
+	slices.SortFunc(sessions, func(a, b models.Session) int {
+		return b.StartedAt.Compare(a.StartedAt)
+	})
+
+	return sessions[:min(limit, len(sessions))], nil
+}
+
+func (s *memoryStore) PruneSessions(ctx context.Context, userId string, keep int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u := s.get(userId, false)
+	if u == nil {
+		return nil
+	}
+
+	sessions := make([]models.Session, 0, len(u.sessions))
+	for _, session := range u.sessions {
+		sessions = append(sessions, session)
+	}
+	slices.SortFunc(sessions, func(a, b models.Session) int {
+		return b.StartedAt.Compare(a.StartedAt)
+	})
+
+	for _, session := range sessions[min(keep, len(sessions)):] {
+		if session.Id == u.user.SessionId {
+			continue
+		}
+		delete(u.sessions, session.Id)
+		delete(u.histories, session.Id)
+		delete(u.summaries, session.Id)
+	}
+
+	return nil
+}
+
+func (s *memoryStore) SavePendingAnswer(ctx context.Context, userId string, answer *models.PendingAnswer) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	saved := *answer
+	s.get(userId, true).pendingAnswer = &saved
+
+	return nil
+}
+
+func (s *memoryStore) UpdatePendingAnswer(ctx context.Context, userId string, update func(answer *models.PendingAnswer) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u := s.get(userId, false)
+	if u == nil || u.pendingAnswer == nil {
+		return ErrNotFound
+	}
+
+	answer := *u.pendingAnswer
+	if err := update(&answer); err != nil {
+		return err
+	}
+
+	u.pendingAnswer = &answer
+	return nil
+}
+
+func (s *memoryStore) DeletePendingAnswer(ctx context.Context, userId string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if u := s.get(userId, false); u != nil {
+		u.pendingAnswer = nil
+	}
+
+	return nil
+}
+
+// cloneUser copies the parts of user held by pointer or slice, so that
+// callers cannot change the stored user through them.
+func cloneUser(user models.User) models.User {
+	user.Caregivers = append([]string(nil), user.Caregivers...)
+	user.Elders = append([]string(nil), user.Elders...)
+	if user.SharingConsentAt != nil {
+		consentAt := *user.SharingConsentAt
+		user.SharingConsentAt = &consentAt
+	}
+	if user.Handoff != nil {
+		handoff := *user.Handoff
+		user.Handoff = &handoff
+	}
+	return user
+}
+
+func (s *memoryStore) SaveLinkCode(ctx context.Context, code string, userId string, expireAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.linkCodes[code] = memoryLinkCode{userId: userId, expireAt: expireAt}
+	return nil
+}
+
+func (s *memoryStore) TakeLinkCode(ctx context.Context, code string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	linkCode, ok := s.linkCodes[code]
+	if !ok {
+		return "", ErrNotFound
+	}
+
+	delete(s.linkCodes, code)
+	if time.Now().After(linkCode.expireAt) {
+		return "", ErrNotFound
+	}
+
+	return linkCode.userId, nil
+}
+
+func (s *memoryStore) AddActivity(ctx context.Context, userId string, activity models.Activity) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u := s.get(userId, true)
+	u.activities = append(u.activities, activity)
+	return nil
+}
+
+func (s *memoryStore) ListActivities(ctx context.Context, userId string, since time.Time) ([]models.Activity, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	activities := make([]models.Activity, 0)
+	if u := s.get(userId, false); u != nil {
+		for _, activity := range u.activities {
+			if !activity.Timestamp.Before(since) {
+				activities = append(activities, activity)
+			}
+		}
+	}
+
+	return activities, nil
+}
+
+func (s *memoryStore) DeleteActivities(ctx context.Context, userId string, before time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u := s.get(userId, false)
+	if u == nil {
+		return nil
+	}
+
+	kept := u.activities[:0]
+	for _, activity := range u.activities {
+		if !activity.Timestamp.Before(before) {
+			kept = append(kept, activity)
+		}
+	}
+	u.activities = kept
+
+	return nil
+}
+
+func (s *memoryStore) AddAlert(ctx context.Context, alert models.Alert) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	alert.Recipients = append([]string(nil), alert.Recipients...)
+	s.alerts = append(s.alerts, alert)
+	return nil
+}
+
+func (s *memoryStore) ClaimCooldown(ctx context.Context, userId string, rule string, now time.Time, cooldown time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := cooldownKey(userId, rule)
+	if started, ok := s.cooldowns[key]; ok && now.Sub(started) < cooldown {
+		return false, nil
+	}
+	s.cooldowns[key] = now
+
+	return true, nil
+}
+
+func (s *memoryStore) ReleaseCooldown(ctx context.Context, userId string, rule string, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := cooldownKey(userId, rule)
+	if started, ok := s.cooldowns[key]; ok && started.Equal(now) {
+		delete(s.cooldowns, key)
+	}
+
+	return nil
+}
+
+func (s *memoryStore) ListAlerts(ctx context.Context, since time.Time) ([]models.Alert, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	alerts := make([]models.Alert, 0)
+	for i := len(s.alerts) - 1; i >= 0; i-- {
+		if s.alerts[i].Timestamp.Before(since) {
+			break
+		}
+		alerts = append(alerts, s.alerts[i])
+	}
+
+	return alerts, nil
+}
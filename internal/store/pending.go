@@ -0,0 +1,73 @@
+package store
+
+import (
+	"fmt"
+	"larn-line/internal/models"
+)
+
+// pendingItem is how a message of a pending answer is persisted. Firestore
+// cannot nest arrays, so pages are flattened into one list of items.
+type pendingItem struct {
+	Page     int    `json:"page" firestore:"page"`
+	Type     string `json:"type" firestore:"type"`
+	Text     string `json:"text,omitempty" firestore:"text,omitempty"`
+	Original string `json:"original,omitempty" firestore:"original,omitempty"`
+	Preview  string `json:"preview,omitempty" firestore:"preview,omitempty"`
+	Duration int64  `json:"duration,omitempty" firestore:"duration,omitempty"`
+}
+
+func encodePages(pages [][]models.TmpHistory) ([]pendingItem, error) {
+	items := make([]pendingItem, 0)
+
+	for page, messages := range pages {
+		for _, message := range messages {
+			item := pendingItem{Page: page}
+
+			switch m := message.Message.(type) {
+			case models.TextMessage:
+				item.Type, item.Text = "message", m.Text
+			case models.ImageMessage:
+				item.Type, item.Original, item.Preview = "image", m.Original, m.Preview
+			case models.AudioMessage:
+				item.Type, item.Original, item.Duration = "audio", m.Url, m.Duration
+			case models.RawMessage:
+				item.Type, item.Text = "raw", m.JSON
+			default:
+				return nil, fmt.Errorf("unsupported pending message %T", message.Message)
+			}
+
+			items = append(items, item)
+		}
+	}
+
+	return items, nil
+}
+
+func decodePages(items []pendingItem) [][]models.TmpHistory {
+	pages := make([][]models.TmpHistory, 0)
+
+	for _, item := range items {
+		for len(pages) <= item.Page {
+			pages = append(pages, make([]models.TmpHistory, 0))
+		}
+
+		var message any
+
+		switch item.Type {
+		case "message":
+			message = models.TextMessage{Text: item.Text}
+		case "image":
+			message = models.ImageMessage{Original: item.Original, Preview: item.Preview}
+		case "audio":
+			message = models.AudioMessage{Url: item.Original, Duration: item.Duration}
+		case "raw":
+			message = models.RawMessage{JSON: item.Text}
+		default:
+			continue
+		}
+
+		pages[item.Page] = append(pages[item.Page], models.TmpHistory{Message: message})
+	}
+
+	return pages
+}
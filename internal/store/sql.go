@@ -0,0 +1,816 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"larn-line/internal/models"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+	_ "modernc.org/sqlite"
+)
+
+type Dialect string
+
+const (
+	SQLite   Dialect = "sqlite"
+	Postgres Dialect = "postgres"
+)
+
+type sqlStore struct {
+	db      *sql.DB
+	dialect Dialect
+}
+
+// NewSQLStore opens dsn with the driver for dialect and brings the schema up
+// to date. For SQLite dsn is a file path such as "larn.db"; for PostgreSQL
+// it is a connection URL.
+func NewSQLStore(ctx context.Context, dialect Dialect, dsn string) (Store, error) {
+	var driver string
+
+	switch dialect {
+	case SQLite:
+		driver = "sqlite"
+		dsn = sqliteDSN(dsn)
+	case Postgres:
+		driver = "pgx"
+	default:
+		return nil, fmt.Errorf("unknown sql dialect %q", dialect)
+	}
+
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	if dialect == SQLite {
+		// SQLite allows a single writer; serialising connections avoids
+		// SQLITE_BUSY errors from concurrent transactions.
+		db.SetMaxOpenConns(1)
+	}
+
+	s := &sqlStore{
+		db:      db,
+		dialect: dialect,
+	}
+
+	if err := s.migrate(ctx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("migrate: %w", err)
+	}
+
+	return s, nil
+}
+
+func sqliteDSN(dsn string) string {
+	if !strings.HasPrefix(dsn, "file:") {
+		dsn = "file:" + dsn
+	}
+
+	separator := "?"
+	if strings.Contains(dsn, "?") {
+		separator = "&"
+	}
+
+	return dsn + separator + "_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)"
+}
+
+func (s *sqlStore) migrate(ctx context.Context) error {
+	if _, err := s.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY
+	)`); err != nil {
+		return err
+	}
+
+	var current int
+	if err := s.db.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&current); err != nil {
+		return err
+	}
+
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+
+		statements := m.sqlite
+		if s.dialect == Postgres {
+			statements = m.postgres
+		}
+
+		err := s.inTx(ctx, func(tx *sql.Tx) error {
+			for _, statement := range statements {
+				if _, err := tx.ExecContext(ctx, statement); err != nil {
+					return err
+				}
+			}
+
+			_, err := tx.ExecContext(ctx, s.rebind(`INSERT INTO schema_migrations (version) VALUES (?)`), m.version)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("version %d: %w", m.version, err)
+		}
+	}
+
+	return nil
+}
+
+// rebind rewrites ? placeholders to $n for PostgreSQL.
+func (s *sqlStore) rebind(query string) string {
+	if s.dialect != Postgres {
+		return query
+	}
+
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+
+	return b.String()
+}
+
+func (s *sqlStore) inTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *sqlStore) GetUser(ctx context.Context, userId string) (*models.User, error) {
+	var profile string
+
+	err := s.db.QueryRowContext(ctx, s.rebind(`SELECT profile FROM users WHERE id = ?`), userId).Scan(&profile)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	var user models.User
+	if err := json.Unmarshal([]byte(profile), &user); err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+func (s *sqlStore) CreateUserIfNotExist(ctx context.Context, userId string) error {
+	profile, err := json.Marshal(models.User{})
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.ExecContext(ctx,
+		s.rebind(`INSERT INTO users (id, profile, updated_at) VALUES (?, ?, ?) ON CONFLICT (id) DO NOTHING`),
+		userId, string(profile), time.Now(),
+	)
+	return err
+}
+
+func (s *sqlStore) UpdateUser(ctx context.Context, userId string, update func(user *models.User) error) error {
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		user, err := s.lockUser(ctx, tx, userId)
+		if err != nil {
+			return err
+		}
+
+		if err := update(user); err != nil {
+			return err
+		}
+
+		return s.putUser(ctx, tx, userId, user)
+	})
+}
+
+// lockUser reads the user for an update within tx. A user that does not
+// exist yet comes back empty.
+func (s *sqlStore) lockUser(ctx context.Context, tx *sql.Tx, userId string) (*models.User, error) {
+	query := `SELECT profile FROM users WHERE id = ?`
+	if s.dialect == Postgres {
+		query += ` FOR UPDATE`
+	}
+
+	var user models.User
+	var profile string
+
+	err := tx.QueryRowContext(ctx, s.rebind(query), userId).Scan(&profile)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+	case err != nil:
+		return nil, err
+	default:
+		if err := json.Unmarshal([]byte(profile), &user); err != nil {
+			return nil, err
+		}
+	}
+
+	return &user, nil
+}
+
+func (s *sqlStore) putUser(ctx context.Context, tx *sql.Tx, userId string, user *models.User) error {
+	updated, err := json.Marshal(user)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx,
+		s.rebind(`INSERT INTO users (id, profile, mode, updated_at) VALUES (?, ?, ?, ?)
+			ON CONFLICT (id) DO UPDATE SET profile = excluded.profile, mode = excluded.mode, updated_at = excluded.updated_at`),
+		userId, string(updated), user.Mode, time.Now(),
+	)
+	return err
+}
+
+func (s *sqlStore) DeleteUser(ctx context.Context, userId string) error {
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		for _, query := range []string{
+			`DELETE FROM messages WHERE user_id = ?`,
+			`DELETE FROM pending_answers WHERE user_id = ?`,
+			`DELETE FROM activities WHERE user_id = ?`,
+			`DELETE FROM summaries WHERE user_id = ?`,
+			`DELETE FROM sessions WHERE user_id = ?`,
+			`DELETE FROM cooldowns WHERE user_id = ?`,
+			`DELETE FROM users WHERE id = ?`,
+		} {
+			if _, err := tx.ExecContext(ctx, s.rebind(query), userId); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *sqlStore) ListUsersByMode(ctx context.Context, mode string) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, s.rebind(`SELECT id FROM users WHERE mode = ?`), mode)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	userIds := make([]string, 0)
+	for rows.Next() {
+		var userId string
+		if err := rows.Scan(&userId); err != nil {
+			return nil, err
+		}
+		userIds = append(userIds, userId)
+	}
+
+	return userIds, rows.Err()
+}
+
+func (s *sqlStore) ListUsersWithCaregivers(ctx context.Context) ([]string, error) {
+	// Caregivers are left out of the profile when there are none.
+	query := `SELECT id FROM users WHERE json_extract(profile, '$.caregivers') IS NOT NULL`
+	if s.dialect == Postgres {
+		query = `SELECT id FROM users WHERE profile ? 'caregivers'`
+	}
+
+	rows, err := s.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	userIds := make([]string, 0)
+	for rows.Next() {
+		var userId string
+		if err := rows.Scan(&userId); err != nil {
+			return nil, err
+		}
+		userIds = append(userIds, userId)
+	}
+
+	return userIds, rows.Err()
+}
+
+func (s *sqlStore) GetHistory(ctx context.Context, userId string, sessionId string) ([]models.History, error) {
+	rows, err := s.db.QueryContext(ctx,
+		s.rebind(`SELECT sender, message, created_at FROM messages WHERE user_id = ? AND session_id = ? ORDER BY id`),
+		userId, sessionId,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	histories := make([]models.History, 0)
+
+	for rows.Next() {
+		var history models.History
+		if err := rows.Scan(&history.From, &history.Message, &history.Timestamp); err != nil {
+			return nil, err
+		}
+		histories = append(histories, history)
+	}
+
+	return histories, rows.Err()
+}
+
+func (s *sqlStore) AddHistory(ctx context.Context, userId string, sessionId string, histories ...models.History) error {
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		return s.addHistory(ctx, tx, userId, sessionId, histories)
+	})
+}
+
+func (s *sqlStore) addHistory(ctx context.Context, tx *sql.Tx, userId string, sessionId string, histories []models.History) error {
+	for _, history := range histories {
+		if history.Timestamp.IsZero() {
+			history.Timestamp = time.Now()
+		}
+
+		if _, err := tx.ExecContext(ctx,
+			s.rebind(`INSERT INTO messages (user_id, session_id, sender, message, created_at) VALUES (?, ?, ?, ?, ?)`),
+			userId, sessionId, history.From, history.Message, history.Timestamp,
+		); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *sqlStore) SaveTurn(ctx context.Context, userId string, update func(user *models.User) (*models.Turn, error)) error {
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		user, err := s.lockUser(ctx, tx, userId)
+		if err != nil {
+			return err
+		}
+
+		left := user.SessionId
+
+		turn, err := update(user)
+		if err != nil {
+			return err
+		}
+
+		if err := s.putUser(ctx, tx, userId, user); err != nil {
+			return err
+		}
+
+		if turn.Session != nil {
+			if left != "" && left != user.SessionId {
+				if _, err := tx.ExecContext(ctx,
+					s.rebind(`UPDATE sessions SET ended_at = ? WHERE user_id = ? AND id = ?`),
+					turn.Session.StartedAt.UTC(), userId, left,
+				); err != nil {
+					return err
+				}
+			}
+
+			if err := s.saveSession(ctx, tx, userId, *turn.Session); err != nil {
+				return err
+			}
+		}
+
+		if turn.Legacy != nil {
+			if err := s.adoptLegacy(ctx, tx, userId, turn); err != nil {
+				return err
+			}
+		}
+
+		return s.addHistory(ctx, tx, userId, user.SessionId, turn.Histories)
+	})
+}
+
+// adoptLegacy moves the turns from before sessions, and their summary, to
+// the turn's Legacy session.
+func (s *sqlStore) adoptLegacy(ctx context.Context, tx *sql.Tx, userId string, turn *models.Turn) error {
+	session := *turn.Legacy
+
+	err := tx.QueryRowContext(ctx,
+		s.rebind(`SELECT created_at FROM messages WHERE user_id = ? AND session_id = '' ORDER BY id LIMIT 1`),
+		userId,
+	).Scan(&session.StartedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		err = tx.QueryRowContext(ctx,
+			s.rebind(`SELECT updated_at FROM summaries WHERE user_id = ? AND session_id = ''`),
+			userId,
+		).Scan(&session.StartedAt)
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, query := range []string{
+		`UPDATE messages SET session_id = ? WHERE user_id = ? AND session_id = ''`,
+		`UPDATE summaries SET session_id = ? WHERE user_id = ? AND session_id = ''`,
+	} {
+		if _, err := tx.ExecContext(ctx, s.rebind(query), session.Id, userId); err != nil {
+			return err
+		}
+	}
+
+	if turn.Session != nil && turn.Session.Id == session.Id {
+		return nil
+	}
+	return s.saveSession(ctx, tx, userId, session)
+}
+
+func (s *sqlStore) ClearHistory(ctx context.Context, userId string) error {
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		for _, query := range []string{
+			`DELETE FROM messages WHERE user_id = ?`,
+			`DELETE FROM summaries WHERE user_id = ?`,
+			`DELETE FROM sessions WHERE user_id = ?`,
+		} {
+			if _, err := tx.ExecContext(ctx, s.rebind(query), userId); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *sqlStore) CompactHistory(ctx context.Context, userId string, sessionId string, n int, summary models.Summary) error {
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx,
+			s.rebind(`DELETE FROM messages WHERE id IN (
+				SELECT id FROM messages WHERE user_id = ? AND session_id = ? ORDER BY id LIMIT ?
+			)`),
+			userId, sessionId, n,
+		); err != nil {
+			return err
+		}
+
+		_, err := tx.ExecContext(ctx,
+			s.rebind(`INSERT INTO summaries (user_id, session_id, text, updated_at) VALUES (?, ?, ?, ?)
+				ON CONFLICT (user_id, session_id) DO UPDATE SET text = excluded.text, updated_at = excluded.updated_at`),
+			userId, sessionId, summary.Text, summary.UpdatedAt,
+		)
+		return err
+	})
+}
+
+func (s *sqlStore) GetSummary(ctx context.Context, userId string, sessionId string) (*models.Summary, error) {
+	var summary models.Summary
+
+	err := s.db.QueryRowContext(ctx,
+		s.rebind(`SELECT text, updated_at FROM summaries WHERE user_id = ? AND session_id = ?`),
+		userId, sessionId,
+	).Scan(&summary.Text, &summary.UpdatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	return &summary, nil
+}
+
+func (s *sqlStore) SaveSession(ctx context.Context, userId string, session models.Session) error {
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		return s.saveSession(ctx, tx, userId, session)
+	})
+}
+
+func (s *sqlStore) saveSession(ctx context.Context, tx *sql.Tx, userId string, session models.Session) error {
+	var endedAt sql.NullTime
+	if session.EndedAt != nil {
+		endedAt = sql.NullTime{Time: session.EndedAt.UTC(), Valid: true}
+	}
+
+	_, err := tx.ExecContext(ctx,
+		s.rebind(`INSERT INTO sessions (user_id, id, agent, title, started_at, ended_at) VALUES (?, ?, ?, ?, ?, ?)
+			ON CONFLICT (user_id, id) DO UPDATE SET
+				agent = excluded.agent,
+				title = excluded.title,
+				started_at = excluded.started_at,
+				ended_at = excluded.ended_at`),
+		userId, session.Id, session.Agent, session.Title, session.StartedAt.UTC(), endedAt,
+	)
+	return err
+}
+
+func (s *sqlStore) GetSession(ctx context.Context, userId string, sessionId string) (*models.Session, error) {
+	row := s.db.QueryRowContext(ctx,
+		s.rebind(`SELECT id, agent, title, started_at, ended_at FROM sessions WHERE user_id = ? AND id = ?`),
+		userId, sessionId,
+	)
+
+	session, err := scanSession(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	return session, nil
+}
+
+func (s *sqlStore) ListSessions(ctx context.Context, userId string, limit int) ([]models.Session, error) {
+	rows, err := s.db.QueryContext(ctx,
+		s.rebind(`SELECT id, agent, title, started_at, ended_at FROM sessions
+			WHERE user_id = ? ORDER BY started_at DESC LIMIT ?`),
+		userId, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := make([]models.Session, 0)
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, *session)
+	}
+
+	return sessions, rows.Err()
+}
+
+func (s *sqlStore) PruneSessions(ctx context.Context, userId string, keep int) error {
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		user, err := s.lockUser(ctx, tx, userId)
+		if err != nil {
+			return err
+		}
+
+		rows, err := tx.QueryContext(ctx,
+			s.rebind(`SELECT id FROM sessions WHERE user_id = ? ORDER BY started_at DESC`),
+			userId,
+		)
+		if err != nil {
+			return err
+		}
+
+		pruned := make([]string, 0)
+		for i := 0; rows.Next(); i++ {
+			var sessionId string
+			if err := rows.Scan(&sessionId); err != nil {
+				rows.Close()
+				return err
+			}
+			if i >= keep && sessionId != user.SessionId {
+				pruned = append(pruned, sessionId)
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		for _, sessionId := range pruned {
+			for _, query := range []string{
+				`DELETE FROM messages WHERE user_id = ? AND session_id = ?`,
+				`DELETE FROM summaries WHERE user_id = ? AND session_id = ?`,
+				`DELETE FROM sessions WHERE user_id = ? AND id = ?`,
+			} {
+				if _, err := tx.ExecContext(ctx, s.rebind(query), userId, sessionId); err != nil {
+					return err
+				}
+			}
+		}
+
+		return nil
+	})
+}
+
+func scanSession(row interface{ Scan(dest ...any) error }) (*models.Session, error) {
+	var session models.Session
+	var endedAt sql.NullTime
+
+	if err := row.Scan(&session.Id, &session.Agent, &session.Title, &session.StartedAt, &endedAt); err != nil {
+		return nil, err
+	}
+	if endedAt.Valid {
+		session.EndedAt = &endedAt.Time
+	}
+
+	return &session, nil
+}
+
+func (s *sqlStore) SavePendingAnswer(ctx context.Context, userId string, answer *models.PendingAnswer) error {
+	items, err := encodePages(answer.Pages)
+	if err != nil {
+		return err
+	}
+
+	pages, err := json.Marshal(items)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.ExecContext(ctx,
+		s.rebind(`INSERT INTO pending_answers (user_id, answer_id, pages, next_page, expire_at) VALUES (?, ?, ?, ?, ?)
+			ON CONFLICT (user_id) DO UPDATE SET answer_id = excluded.answer_id, pages = excluded.pages,
+				next_page = excluded.next_page, expire_at = excluded.expire_at`),
+		userId, answer.AnswerId, string(pages), answer.NextPage, answer.ExpireAt,
+	)
+	return err
+}
+
+func (s *sqlStore) UpdatePendingAnswer(ctx context.Context, userId string, update func(answer *models.PendingAnswer) error) error {
+	query := `SELECT answer_id, pages, next_page, expire_at FROM pending_answers WHERE user_id = ?`
+	if s.dialect == Postgres {
+		query += ` FOR UPDATE`
+	}
+
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		var answer models.PendingAnswer
+		var pages string
+
+		err := tx.QueryRowContext(ctx, s.rebind(query), userId).Scan(&answer.AnswerId, &pages, &answer.NextPage, &answer.ExpireAt)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrNotFound
+			}
+			return err
+		}
+
+		var items []pendingItem
+		if err := json.Unmarshal([]byte(pages), &items); err != nil {
+			return err
+		}
+		answer.Pages = decodePages(items)
+
+		if err := update(&answer); err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx,
+			s.rebind(`UPDATE pending_answers SET answer_id = ?, next_page = ?, expire_at = ? WHERE user_id = ?`),
+			answer.AnswerId, answer.NextPage, answer.ExpireAt, userId,
+		)
+		return err
+	})
+}
+
+func (s *sqlStore) DeletePendingAnswer(ctx context.Context, userId string) error {
+	_, err := s.db.ExecContext(ctx, s.rebind(`DELETE FROM pending_answers WHERE user_id = ?`), userId)
+	return err
+}
+
+func (s *sqlStore) SaveLinkCode(ctx context.Context, code string, userId string, expireAt time.Time) error {
+	_, err := s.db.ExecContext(ctx,
+		s.rebind(`INSERT INTO link_codes (code, user_id, expire_at) VALUES (?, ?, ?)
+			ON CONFLICT (code) DO UPDATE SET user_id = excluded.user_id, expire_at = excluded.expire_at`),
+		code, userId, expireAt,
+	)
+	return err
+}
+
+func (s *sqlStore) TakeLinkCode(ctx context.Context, code string) (string, error) {
+	var userId string
+	var expireAt time.Time
+
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx, s.rebind(`SELECT user_id, expire_at FROM link_codes WHERE code = ?`), code).Scan(&userId, &expireAt)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrNotFound
+			}
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, s.rebind(`DELETE FROM link_codes WHERE code = ?`), code)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if time.Now().After(expireAt) {
+		return "", ErrNotFound
+	}
+
+	return userId, nil
+}
+
+// Activity times are kept in UTC so that SQLite, which stores them as text,
+// compares them in order.
+func (s *sqlStore) AddActivity(ctx context.Context, userId string, activity models.Activity) error {
+	_, err := s.db.ExecContext(ctx,
+		s.rebind(`INSERT INTO activities (user_id, kind, classification, excerpt, created_at) VALUES (?, ?, ?, ?, ?)`),
+		userId, activity.Kind, activity.Classification, activity.Excerpt, activity.Timestamp.UTC(),
+	)
+	return err
+}
+
+func (s *sqlStore) ListActivities(ctx context.Context, userId string, since time.Time) ([]models.Activity, error) {
+	rows, err := s.db.QueryContext(ctx,
+		s.rebind(`SELECT kind, classification, excerpt, created_at FROM activities
+			WHERE user_id = ? AND created_at >= ? ORDER BY created_at`),
+		userId, since.UTC(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	activities := make([]models.Activity, 0)
+	for rows.Next() {
+		var activity models.Activity
+		if err := rows.Scan(&activity.Kind, &activity.Classification, &activity.Excerpt, &activity.Timestamp); err != nil {
+			return nil, err
+		}
+		activities = append(activities, activity)
+	}
+
+	return activities, rows.Err()
+}
+
+func (s *sqlStore) DeleteActivities(ctx context.Context, userId string, before time.Time) error {
+	_, err := s.db.ExecContext(ctx,
+		s.rebind(`DELETE FROM activities WHERE user_id = ? AND created_at < ?`),
+		userId, before.UTC(),
+	)
+	return err
+}
+
+func (s *sqlStore) AddAlert(ctx context.Context, alert models.Alert) error {
+	recipients, err := json.Marshal(alert.Recipients)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.ExecContext(ctx,
+		s.rebind(`INSERT INTO alerts (rule, user_id, recipients, classification, excerpt, created_at) VALUES (?, ?, ?, ?, ?, ?)`),
+		alert.Rule, alert.UserId, string(recipients), alert.Classification, alert.Excerpt, alert.Timestamp.UTC(),
+	)
+	return err
+}
+
+// ClaimCooldown inserts the claim, or takes over one that has run out, in a
+// single statement; a claim still running leaves no row affected.
+func (s *sqlStore) ClaimCooldown(ctx context.Context, userId string, rule string, now time.Time, cooldown time.Duration) (bool, error) {
+	res, err := s.db.ExecContext(ctx,
+		s.rebind(`INSERT INTO cooldowns (user_id, rule, started_at) VALUES (?, ?, ?)
+			ON CONFLICT (user_id, rule) DO UPDATE SET started_at = excluded.started_at
+			WHERE cooldowns.started_at <= ?`),
+		userId, rule, now.UTC(), now.Add(-cooldown).UTC(),
+	)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+func (s *sqlStore) ReleaseCooldown(ctx context.Context, userId string, rule string, now time.Time) error {
+	_, err := s.db.ExecContext(ctx,
+		s.rebind(`DELETE FROM cooldowns WHERE user_id = ? AND rule = ? AND started_at = ?`),
+		userId, rule, now.UTC(),
+	)
+	return err
+}
+
+func (s *sqlStore) ListAlerts(ctx context.Context, since time.Time) ([]models.Alert, error) {
+	return s.queryAlerts(ctx,
+		`SELECT rule, user_id, recipients, classification, excerpt, created_at FROM alerts
+			WHERE created_at >= ? ORDER BY created_at DESC`,
+		since.UTC(),
+	)
+}
+
+func (s *sqlStore) queryAlerts(ctx context.Context, query string, args ...any) ([]models.Alert, error) {
+	rows, err := s.db.QueryContext(ctx, s.rebind(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	alerts := make([]models.Alert, 0)
+	for rows.Next() {
+		var alert models.Alert
+		var recipients string
+		if err := rows.Scan(&alert.Rule, &alert.UserId, &recipients, &alert.Classification, &alert.Excerpt, &alert.Timestamp); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(recipients), &alert.Recipients); err != nil {
+			return nil, err
+		}
+		alerts = append(alerts, alert)
+	}
+
+	return alerts, rows.Err()
+}
@@ -0,0 +1,258 @@
+package store
+
+// migration is one schema change. Statements are given per dialect where
+// SQLite and PostgreSQL disagree, mostly on auto-increment keys.
+type migration struct {
+	version  int
+	sqlite   []string
+	postgres []string
+}
+
+var migrations = []migration{
+	{
+		version: 1,
+		sqlite: []string{
+			`CREATE TABLE users (
+				id TEXT PRIMARY KEY,
+				profile TEXT NOT NULL,
+				updated_at TIMESTAMP NOT NULL
+			)`,
+			`CREATE TABLE messages (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				user_id TEXT NOT NULL,
+				sender TEXT NOT NULL,
+				message TEXT NOT NULL,
+				created_at TIMESTAMP NOT NULL
+			)`,
+			`CREATE INDEX messages_user_id ON messages (user_id, id)`,
+			`CREATE TABLE tmp_messages (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				user_id TEXT NOT NULL,
+				type TEXT NOT NULL,
+				text TEXT NOT NULL DEFAULT '',
+				original TEXT NOT NULL DEFAULT '',
+				preview TEXT NOT NULL DEFAULT '',
+				created_at TIMESTAMP NOT NULL
+			)`,
+			`CREATE INDEX tmp_messages_user_id ON tmp_messages (user_id, id)`,
+		},
+		postgres: []string{
+			`CREATE TABLE users (
+				id TEXT PRIMARY KEY,
+				profile JSONB NOT NULL,
+				updated_at TIMESTAMPTZ NOT NULL
+			)`,
+			`CREATE TABLE messages (
+				id BIGSERIAL PRIMARY KEY,
+				user_id TEXT NOT NULL,
+				sender TEXT NOT NULL,
+				message TEXT NOT NULL,
+				created_at TIMESTAMPTZ NOT NULL
+			)`,
+			`CREATE INDEX messages_user_id ON messages (user_id, id)`,
+			`CREATE TABLE tmp_messages (
+				id BIGSERIAL PRIMARY KEY,
+				user_id TEXT NOT NULL,
+				type TEXT NOT NULL,
+				text TEXT NOT NULL DEFAULT '',
+				original TEXT NOT NULL DEFAULT '',
+				preview TEXT NOT NULL DEFAULT '',
+				created_at TIMESTAMPTZ NOT NULL
+			)`,
+			`CREATE INDEX tmp_messages_user_id ON tmp_messages (user_id, id)`,
+		},
+	},
+	{
+		version: 2,
+		sqlite: []string{
+			`ALTER TABLE tmp_messages ADD COLUMN duration INTEGER NOT NULL DEFAULT 0`,
+		},
+		postgres: []string{
+			`ALTER TABLE tmp_messages ADD COLUMN duration BIGINT NOT NULL DEFAULT 0`,
+		},
+	},
+	{
+		version: 3,
+		sqlite: []string{
+			`CREATE TABLE pending_answers (
+				user_id TEXT PRIMARY KEY,
+				answer_id TEXT NOT NULL,
+				pages TEXT NOT NULL,
+				next_page INTEGER NOT NULL,
+				expire_at TIMESTAMP NOT NULL
+			)`,
+			`DROP TABLE tmp_messages`,
+		},
+		postgres: []string{
+			`CREATE TABLE pending_answers (
+				user_id TEXT PRIMARY KEY,
+				answer_id TEXT NOT NULL,
+				pages JSONB NOT NULL,
+				next_page INTEGER NOT NULL,
+				expire_at TIMESTAMPTZ NOT NULL
+			)`,
+			`DROP TABLE tmp_messages`,
+		},
+	},
+	{
+		// The mode is copied out of the profile to find conversations
+		// waiting for an operator.
+		version: 4,
+		sqlite: []string{
+			`ALTER TABLE users ADD COLUMN mode TEXT NOT NULL DEFAULT ''`,
+			`CREATE INDEX users_mode ON users (mode)`,
+		},
+		postgres: []string{
+			`ALTER TABLE users ADD COLUMN mode TEXT NOT NULL DEFAULT ''`,
+			`CREATE INDEX users_mode ON users (mode)`,
+		},
+	},
+	{
+		version: 5,
+		sqlite: []string{
+			`CREATE TABLE link_codes (
+				code TEXT PRIMARY KEY,
+				user_id TEXT NOT NULL,
+				expire_at TIMESTAMP NOT NULL
+			)`,
+			`CREATE TABLE activities (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				user_id TEXT NOT NULL,
+				kind TEXT NOT NULL,
+				classification TEXT NOT NULL,
+				excerpt TEXT NOT NULL,
+				created_at TIMESTAMP NOT NULL
+			)`,
+			`CREATE INDEX activities_user_id ON activities (user_id, created_at)`,
+		},
+		postgres: []string{
+			`CREATE TABLE link_codes (
+				code TEXT PRIMARY KEY,
+				user_id TEXT NOT NULL,
+				expire_at TIMESTAMPTZ NOT NULL
+			)`,
+			`CREATE TABLE activities (
+				id BIGSERIAL PRIMARY KEY,
+				user_id TEXT NOT NULL,
+				kind TEXT NOT NULL,
+				classification TEXT NOT NULL,
+				excerpt TEXT NOT NULL,
+				created_at TIMESTAMPTZ NOT NULL
+			)`,
+			`CREATE INDEX activities_user_id ON activities (user_id, created_at)`,
+		},
+	},
+	{
+		version: 6,
+		sqlite: []string{
+			`CREATE TABLE alerts (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				rule TEXT NOT NULL,
+				user_id TEXT NOT NULL,
+				recipients TEXT NOT NULL,
+				classification TEXT NOT NULL,
+				excerpt TEXT NOT NULL,
+				created_at TIMESTAMP NOT NULL
+			)`,
+			`CREATE INDEX alerts_user_id ON alerts (user_id, rule, created_at)`,
+			`CREATE INDEX alerts_created_at ON alerts (created_at)`,
+		},
+		postgres: []string{
+			`CREATE TABLE alerts (
+				id BIGSERIAL PRIMARY KEY,
+				rule TEXT NOT NULL,
+				user_id TEXT NOT NULL,
+				recipients JSONB NOT NULL,
+				classification TEXT NOT NULL,
+				excerpt TEXT NOT NULL,
+				created_at TIMESTAMPTZ NOT NULL
+			)`,
+			`CREATE INDEX alerts_user_id ON alerts (user_id, rule, created_at)`,
+			`CREATE INDEX alerts_created_at ON alerts (created_at)`,
+		},
+	},
+	{
+		version: 7,
+		sqlite: []string{
+			`CREATE TABLE summaries (
+				user_id TEXT PRIMARY KEY,
+				text TEXT NOT NULL,
+				updated_at TIMESTAMP NOT NULL
+			)`,
+		},
+		postgres: []string{
+			`CREATE TABLE summaries (
+				user_id TEXT PRIMARY KEY,
+				text TEXT NOT NULL,
+				updated_at TIMESTAMPTZ NOT NULL
+			)`,
+		},
+	},
+	{
+		// Sessions split a user's history into topics. Existing turns and
+		// summaries keep the empty session id.
+		version: 8,
+		sqlite: []string{
+			`ALTER TABLE messages ADD COLUMN session_id TEXT NOT NULL DEFAULT ''`,
+			`CREATE INDEX messages_user_session ON messages (user_id, session_id, id)`,
+			`CREATE TABLE session_summaries (
+				user_id TEXT NOT NULL,
+				session_id TEXT NOT NULL DEFAULT '',
+				text TEXT NOT NULL,
+				updated_at TIMESTAMP NOT NULL,
+				PRIMARY KEY (user_id, session_id)
+			)`,
+			`INSERT INTO session_summaries (user_id, text, updated_at) SELECT user_id, text, updated_at FROM summaries`,
+			`DROP TABLE summaries`,
+			`ALTER TABLE session_summaries RENAME TO summaries`,
+			`CREATE TABLE sessions (
+				user_id TEXT NOT NULL,
+				id TEXT NOT NULL,
+				agent TEXT NOT NULL,
+				title TEXT NOT NULL,
+				started_at TIMESTAMP NOT NULL,
+				ended_at TIMESTAMP,
+				PRIMARY KEY (user_id, id)
+			)`,
+			`CREATE INDEX sessions_started_at ON sessions (user_id, started_at)`,
+		},
+		postgres: []string{
+			`ALTER TABLE messages ADD COLUMN session_id TEXT NOT NULL DEFAULT ''`,
+			`CREATE INDEX messages_user_session ON messages (user_id, session_id, id)`,
+			`ALTER TABLE summaries ADD COLUMN session_id TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE summaries DROP CONSTRAINT summaries_pkey`,
+			`ALTER TABLE summaries ADD PRIMARY KEY (user_id, session_id)`,
+			`CREATE TABLE sessions (
+				user_id TEXT NOT NULL,
+				id TEXT NOT NULL,
+				agent TEXT NOT NULL,
+				title TEXT NOT NULL,
+				started_at TIMESTAMPTZ NOT NULL,
+				ended_at TIMESTAMPTZ,
+				PRIMARY KEY (user_id, id)
+			)`,
+			`CREATE INDEX sessions_started_at ON sessions (user_id, started_at)`,
+		},
+	},
+	{
+		// Cooldowns are claimed before alerting, replacing the lookup of
+		// the last alert.
+		version: 9,
+		sqlite: []string{
+			`CREATE TABLE cooldowns (
+				user_id TEXT NOT NULL,
+				rule TEXT NOT NULL,
+				started_at TIMESTAMP NOT NULL,
+				PRIMARY KEY (user_id, rule)
+			)`,
+		},
+		postgres: []string{
+			`CREATE TABLE cooldowns (
+				user_id TEXT NOT NULL,
+				rule TEXT NOT NULL,
+				started_at TIMESTAMPTZ NOT NULL,
+				PRIMARY KEY (user_id, rule)
+			)`,
+		},
+	},
+}
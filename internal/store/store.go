@@ -0,0 +1,84 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"larn-line/internal/models"
+	"time"
+)
+
+var ErrNotFound = errors.New("not found")
+
+// Store persists everything the bot knows about a user: the profile, the
+// conversation history sent to Larn and the "read more" pages waiting to be
+// delivered.
+type Store interface {
+	// GetUser returns ErrNotFound when the user has never followed the bot.
+	GetUser(ctx context.Context, userId string) (*models.User, error)
+	CreateUserIfNotExist(ctx context.Context, userId string) error
+	// UpdateUser applies update to the stored user atomically, creating the
+	// user first if needed.
+	UpdateUser(ctx context.Context, userId string, update func(user *models.User) error) error
+	DeleteUser(ctx context.Context, userId string) error
+	// ListUsersByMode returns the ids of users whose conversation is in mode.
+	ListUsersByMode(ctx context.Context, mode string) ([]string, error)
+	// ListUsersWithCaregivers returns the ids of users linked to a caregiver.
+	ListUsersWithCaregivers(ctx context.Context) ([]string, error)
+
+	// GetHistory returns the turns of a session oldest first. The empty
+	// session id holds turns saved before sessions existed.
+	GetHistory(ctx context.Context, userId string, sessionId string) ([]models.History, error)
+	AddHistory(ctx context.Context, userId string, sessionId string, histories ...models.History) error
+	// SaveTurn applies update to the user and adds the turn it returns to
+	// the user's session, in one transaction. When the turn starts a
+	// session, the session is created and the one left is ended. Turns
+	// from before sessions move to the turn's Legacy session.
+	SaveTurn(ctx context.Context, userId string, update func(user *models.User) (*models.Turn, error)) error
+	// ClearHistory deletes every session of the user with its turns and
+	// summary.
+	ClearHistory(ctx context.Context, userId string) error
+	// CompactHistory replaces the oldest n turns of a session with summary.
+	CompactHistory(ctx context.Context, userId string, sessionId string, n int, summary models.Summary) error
+	// GetSummary returns ErrNotFound when no turns were compacted.
+	GetSummary(ctx context.Context, userId string, sessionId string) (*models.Summary, error)
+
+	// SaveSession creates or replaces a session.
+	SaveSession(ctx context.Context, userId string, session models.Session) error
+	// GetSession returns ErrNotFound for an unknown session.
+	GetSession(ctx context.Context, userId string, sessionId string) (*models.Session, error)
+	// ListSessions returns up to limit sessions, latest started first.
+	ListSessions(ctx context.Context, userId string, limit int) ([]models.Session, error)
+	// PruneSessions deletes the sessions started before the latest keep,
+	// with their turns and summaries. The user's current session is kept
+	// however old.
+	PruneSessions(ctx context.Context, userId string, keep int) error
+
+	// SavePendingAnswer replaces any pages left from an earlier answer.
+	SavePendingAnswer(ctx context.Context, userId string, answer *models.PendingAnswer) error
+	// UpdatePendingAnswer applies update to the pending answer atomically.
+	// It returns ErrNotFound when there is none.
+	UpdatePendingAnswer(ctx context.Context, userId string, update func(answer *models.PendingAnswer) error) error
+	DeletePendingAnswer(ctx context.Context, userId string) error
+
+	// SaveLinkCode keeps a one-time code a caregiver uses to link to userId.
+	SaveLinkCode(ctx context.Context, code string, userId string, expireAt time.Time) error
+	// TakeLinkCode returns the user a code was made for and deletes it. It
+	// returns ErrNotFound when the code is unknown or expired.
+	TakeLinkCode(ctx context.Context, code string) (string, error)
+
+	AddActivity(ctx context.Context, userId string, activity models.Activity) error
+	// ListActivities returns the user's activities since the given time,
+	// oldest first.
+	ListActivities(ctx context.Context, userId string, since time.Time) ([]models.Activity, error)
+	DeleteActivities(ctx context.Context, userId string, before time.Time) error
+
+	AddAlert(ctx context.Context, alert models.Alert) error
+	// ClaimCooldown starts the cooldown of rule for userId at now and
+	// reports true, unless one started within cooldown before now. Claims
+	// are atomic, so that only one of several concurrent alerts goes out.
+	ClaimCooldown(ctx context.Context, userId string, rule string, now time.Time, cooldown time.Duration) (bool, error)
+	// ReleaseCooldown undoes the claim made at now.
+	ReleaseCooldown(ctx context.Context, userId string, rule string, now time.Time) error
+	// ListAlerts returns the alerts sent since the given time, newest first.
+	ListAlerts(ctx context.Context, since time.Time) ([]models.Alert, error)
+}
@@ -1,13 +1,18 @@
 package utils
 
 import (
+	"encoding/json"
+	"fmt"
 	"log"
 
 	"github.com/line/line-bot-sdk-go/v8/linebot/messaging_api"
 )
 
-func CreateCallLarnMessage() *messaging_api.FlexMessage {
-	jsonString := `{
+// CreateCallLarnMessage asks the user to add the volunteer at url as a friend.
+func CreateCallLarnMessage(url string) *messaging_api.FlexMessage {
+	uri, _ := json.Marshal(url)
+
+	jsonString := fmt.Sprintf(`{
   "type": "bubble",
   "body": {
     "type": "box",
@@ -38,13 +43,13 @@ func CreateCallLarnMessage() *messaging_api.FlexMessage {
         "action": {
           "type": "uri",
           "label": "เพิ่มเพื่อน",
-          "uri": "https://line.me/ti/p/lP-CvWiMKT"
+          "uri": %s
         }
       }
     ],
     "flex": 0
   }
-}`
+}`, uri)
 	contents, err := messaging_api.UnmarshalFlexContainer([]byte(jsonString))
 	if err != nil {
 		log.Fatal(err)
@@ -25,9 +25,12 @@ func CreateQuickReply(messages []string) *messaging_api.QuickReply {
 		}
 
 		items = append(items, messaging_api.QuickReplyItem{
+			// Typed up front, since marshalling an action without a type
+			// sets it, which races when the items are shared.
 			Action: &messaging_api.MessageAction{
-				Label: label,
-				Text:  string(runes[:msgLen]),
+				Action: messaging_api.Action{Type: "message"},
+				Label:  label,
+				Text:   string(runes[:msgLen]),
 			},
 		},
 		)